@@ -0,0 +1,135 @@
+// Copyright 2023 Dan Good. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package deque
+
+import (
+	"context"
+	"sync"
+)
+
+// BlockingDeque wraps a deque with a limit on its length for use
+// between producer and consumer goroutines.  Producers wait while
+// the deque is full, and consumers wait while it is empty.
+type BlockingDeque[T any] struct {
+	mu                sync.Mutex
+	notFull, notEmpty sync.Cond
+	limit             int
+	d                 Deque[T]
+}
+
+// Return a new blocking deque holding at most limit values.
+// Panic if limit is less than 1.
+func NewBlockingDeque[T any](limit int) *BlockingDeque[T] {
+	if limit < 1 {
+		panic("deque: NewBlockingDeque limit less than 1")
+	}
+	b := &BlockingDeque[T]{limit: limit}
+	b.notFull.L = &b.mu
+	b.notEmpty.L = &b.mu
+	return b
+}
+
+// Wait on c while blocked returns true, or until ctx is done.
+// Only called with mu held.
+func (b *BlockingDeque[T]) wait(ctx context.Context, c *sync.Cond, blocked func() bool) error {
+	if !blocked() {
+		return nil
+	}
+	if ctx.Done() != nil {
+		// Wake the waiter on cancellation.  Taking the lock ensures
+		// the broadcast can't slip in before Wait.
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				b.mu.Lock()
+				c.Broadcast()
+				b.mu.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+	for blocked() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.Wait()
+	}
+	return nil
+}
+
+// Enqueue a value onto the end of the deque, waiting while it is full.
+func (b *BlockingDeque[T]) PushBlocking(v T) {
+	b.PushContext(context.Background(), v)
+}
+
+// Enqueue a value onto the end of the deque, waiting while it is full.
+// Return the context's error if it is done before there is room.
+func (b *BlockingDeque[T]) PushContext(ctx context.Context, v T) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.wait(ctx, &b.notFull, func() bool { return b.d.len >= b.limit })
+	if err != nil {
+		return err
+	}
+	b.d.Push(v)
+	b.notEmpty.Broadcast()
+	return nil
+}
+
+// Return and remove a value from the head of the deque, waiting
+// while it is empty.
+func (b *BlockingDeque[T]) ShiftBlocking() T {
+	v, _ := b.ShiftContext(context.Background())
+	return v
+}
+
+// Return and remove a value from the head of the deque, waiting
+// while it is empty.  Return a zero value and the context's error
+// if it is done before a value arrives.
+func (b *BlockingDeque[T]) ShiftContext(ctx context.Context) (v T, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err = b.wait(ctx, &b.notEmpty, func() bool { return b.d.len == 0 })
+	if err != nil {
+		return
+	}
+	v, _ = b.d.Shift()
+	b.notFull.Broadcast()
+	return
+}
+
+// Return and remove a value from the head of the deque without
+// waiting, either for a value or for the lock.  When acquired is
+// false, the lock was busy and the deque was not examined, so try
+// again later; this is distinct from ok being false for an empty deque.
+func (b *BlockingDeque[T]) TryShift() (v T, ok, acquired bool) {
+	if !b.mu.TryLock() {
+		return
+	}
+	defer b.mu.Unlock()
+	acquired = true
+	if v, ok = b.d.Shift(); ok {
+		b.notFull.Broadcast()
+	}
+	return
+}
+
+// Length of the deque
+func (b *BlockingDeque[T]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.d.len
+}
+
+// Return a copy of the values, from head to tail, taken under the
+// lock so that readers get a consistent view without holding it while
+// they work.  The copy costs O(n) with the lock held.
+func (b *BlockingDeque[T]) Snapshot() []T {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.d.ToSliceInto(nil)
+}
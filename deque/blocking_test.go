@@ -0,0 +1,91 @@
+package deque
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBlocking(t *testing.T) {
+	b := NewBlockingDeque[int](2)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 100; i++ {
+			b.PushBlocking(i)
+		}
+	}()
+	for i := 1; i <= 100; i++ {
+		if n := b.ShiftBlocking(); n != i {
+			t.Fatalf("got %v, expected %v", n, i)
+		}
+		if n := b.Len(); n > 2 {
+			t.Fatalf("length %d, expected at most %d", n, 2)
+		}
+	}
+	wg.Wait()
+}
+
+func TestBlockingContext(t *testing.T) {
+	b := NewBlockingDeque[int](1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.ShiftContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	if err := b.PushContext(context.Background(), 1); err != nil {
+		t.Errorf("got %v, expected nil", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := b.PushContext(ctx, 2); err != context.Canceled {
+		t.Errorf("got %v, expected %v", err, context.Canceled)
+	}
+	if n, err := b.ShiftContext(ctx); err != nil || n != 1 {
+		t.Errorf("got %v/%v, expected %v/nil", n, err, 1)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	b := NewBlockingDeque[int](4)
+	if s := b.Snapshot(); len(s) != 0 {
+		t.Errorf("got %v, expected empty slice", s)
+	}
+	for i := 1; i <= 4; i++ {
+		b.PushBlocking(i)
+	}
+	b.ShiftBlocking()
+	b.PushBlocking(5)
+	s := b.Snapshot()
+	es := []int{2, 3, 4, 5}
+	if !reflect.DeepEqual(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+	s[0] = 0
+	if n := b.ShiftBlocking(); n != 2 {
+		t.Errorf("got %v, expected %v", n, 2)
+	}
+}
+
+func TestTryShift(t *testing.T) {
+	b := NewBlockingDeque[int](2)
+	if _, ok, acquired := b.TryShift(); ok || !acquired {
+		t.Errorf("got %v/%v, expected false/true", ok, acquired)
+	}
+	b.PushBlocking(1)
+	b.mu.Lock()
+	if _, ok, acquired := b.TryShift(); ok || acquired {
+		t.Errorf("got %v/%v, expected false/false", ok, acquired)
+	}
+	b.mu.Unlock()
+	if n, ok, acquired := b.TryShift(); n != 1 || !ok || !acquired {
+		t.Errorf("got %v/%v/%v, expected 1/true/true", n, ok, acquired)
+	}
+}
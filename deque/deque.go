@@ -7,6 +7,16 @@
 // grows by doubling to amortize allocations.
 package deque
 
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
 // Slice size to use when none is specified.
 const DefaultSize = 32
 
@@ -23,10 +33,85 @@ const (
 // Deque tracks where to enqueue or dequeue for both
 // sides of the deque.  A zero-valued deque is usable
 // and will allocate on first enqueue.
+//
+// When OnHighWatermark is set, it is called once as the length
+// rises to HighWatermark or above.  It is not called again until
+// the length has fallen below LowWatermark (or HighWatermark, if
+// LowWatermark is unset), at which point OnLowWatermark is called.
+//
+// When ShrinkFloor is greater than Minsize, a deque shrinks no
+// further than ShrinkFloor, though Minsize still decides whether the
+// length is small enough to shrink.
+//
+// When AppendOnly is true, a deque is a strict FIFO queue: Unshift,
+// PrependSlice, Pop, DiscardBack, and TransferTailToHead panic rather
+// than add to the head or remove from the end, as does InsertAtFast
+// anywhere but the end.
+//
+// When GrowBase is set, a deque grows to GrowBase times a power of
+// two, rather than doubling its current capacity, so the growth sizes
+// are independent of Minsize.
+//
+// When GrowStep is set, a deque grows by adding GrowStep to its
+// capacity, as many times as needed, rather than doubling.  It takes
+// precedence over GrowBase, while MaxResize still applies.
+//
+// When MaxResize is set, growth stops doubling at MaxResize.  Beyond
+// it, a deque grows by at most MaxResize at a time, unless more is
+// needed to fit the values being added.
+//
+// When QuantizeCap is set, every capacity a deque grows or shrinks to
+// is passed through it last, after Policy, GrowBase, and MaxResize,
+// so sizes can be rounded to suit an allocator.  A result smaller than
+// the capacity asked for is ignored, as is a shrink to no less than
+// the current capacity.
+//
+// When ShrinkDecider is set, it is consulted after removals in place
+// of the Shrink mode.  It returns whether to shrink, and if so, the
+// capacity to shrink to, which is raised to Minsize if below it.  A
+// capacity no smaller than the current one leaves the store as it is.
+//
+// When Policy is set, it decides growing and shrinking in place of
+// the doubling, GrowBase, MaxResize, the Shrink mode, ShrinkDecider,
+// and ShrinkFloor.
+//
+// ReservedCap is a capacity below which a deque never shrinks,
+// whatever the Shrink mode, ShrinkDecider, or Policy decide, so a
+// pooled deque can keep a warm backing store.
+//
+// SoftCap is a length past which PushSoft reports overload.  Growth
+// is unaffected, and zero means no limit.
+//
+// Slots vacated by removal are cleared, so a deque does not keep
+// removed values reachable.  When ZeroSet is true, they are
+// overwritten with ZeroValue rather than the zero value of T.  Unused slots of
+// a newly allocated backing store still hold the zero value of T.
+//
+// When ZeroOnGrow is true, a backing store replaced by a resize or
+// by UnmarshalState is cleared first, so no copies of values linger
+// in memory awaiting garbage collection.  This costs a pass over the
+// old store on every resize, so it is off by default.
+// A slice adopted through WrapSlice, WrapRing, or Rebind belongs to
+// the caller and is never cleared, and those methods leave the store
+// they replace as it is, since it may share an array with the new one.
 type Deque[T any] struct {
-	Minsize, Shrink int
-	head, tail, len int
-	dat             []T
+	Minsize, Shrink, ShrinkFloor    int
+	ReservedCap                     int
+	GrowBase, GrowStep, MaxResize   int
+	QuantizeCap                     func(needed int) int
+	Policy                          CapacityPolicy
+	ShrinkDecider                   func(len, cap, minsize int) (target int, shrink bool)
+	HighWatermark, LowWatermark     int
+	SoftCap                         int
+	OnHighWatermark, OnLowWatermark func(len int)
+	ZeroValue                       T
+	ZeroSet, ZeroOnGrow, AppendOnly bool
+	head, tail, len                 int
+	suspended, pinned               int
+	high, seqon, adopted            bool
+	nextseq                         uint64
+	seq                             []uint64
+	dat                             []T
 }
 
 // A deque changes size by copying into a new slice.
@@ -43,7 +128,9 @@ func (d *Deque[T]) resize(size int) {
 			copy(tmp[count:], d.dat[:d.len-count])
 		}
 	}
-	d.dat = tmp
+	d.release()
+	d.relocateSeq(size, 0)
+	d.dat, d.adopted = tmp, false
 	d.head = 0
 	d.tail = d.len
 	if d.tail == 0 {
@@ -52,36 +139,445 @@ func (d *Deque[T]) resize(size int) {
 	d.tail--
 }
 
+// Clear the backing store before it is replaced, if configured and
+// the deque allocated it.
+func (d *Deque[T]) release() {
+	if d.ZeroOnGrow && !d.adopted {
+		clear(d.dat)
+	}
+}
+
+// CapacityPolicy decides the capacity of a deque's backing store.
+// Grow returns the capacity needed to hold add more values, or cap
+// to keep the current store.  Shrink is consulted after removals and
+// returns the capacity to shrink to, or cap to keep the current store;
+// a larger capacity also keeps it.
+type CapacityPolicy interface {
+	Grow(len, cap, add int) int
+	Shrink(len, cap, minsize int) int
+}
+
+// DefaultPolicy is the sizing a deque uses when it has no policy:
+// grow by doubling, starting from Minsize, and shrink to Minsize
+// according to Mode, one of the Shrink constants.  A Minsize of 0
+// means DefaultSize, and the deque's own Minsize is not consulted.
+type DefaultPolicy struct {
+	Minsize, Mode int
+}
+
+func (p DefaultPolicy) minsize() int {
+	if p.Minsize <= 0 {
+		return DefaultSize
+	}
+	return p.Minsize
+}
+
+// Double until add more values fit.
+func (p DefaultPolicy) Grow(len, cap, add int) int {
+	if len+add <= cap {
+		return cap
+	}
+	return growSize(p.minsize(), len, cap, add)
+}
+
+// Shrink to Minsize as the mode allows.
+func (p DefaultPolicy) Shrink(len, cap, _ int) int {
+	return shrinkSize(p.Mode, len, cap, p.minsize())
+}
+
+// Double from the current capacity, or minsize, until add more fit.
+func growSize(minsize, len, cap, add int) int {
+	size := cap
+	if size == 0 {
+		size = minsize
+	}
+	for size < len+add {
+		size *= 2
+	}
+	return size
+}
+
+// Return the capacity an empty deque with the given Minsize settles
+// on to hold target values, by the same doubling Push uses, without
+// allocating.  GrowBase, MaxResize, and Policy are not considered.
+func CapacityFor(minsize, target int) int {
+	if target <= 0 {
+		return 0
+	}
+	if minsize <= 0 {
+		minsize = DefaultSize
+	}
+	return growSize(minsize, 0, 0, target)
+}
+
+// Return minsize when the shrink mode calls for shrinking, or cap.
+func shrinkSize(mode, len, cap, minsize int) int {
+	if mode == ShrinkNever {
+		return cap
+	}
+	if len > minsize || cap == minsize {
+		return cap
+	}
+	if mode == ShrinkAt20Pct && len*5 > cap {
+		return cap
+	}
+	if mode == ShrinkIfEmpty && len > 0 {
+		return cap
+	}
+	return minsize
+}
+
 func (d *Deque[T]) grow(add int) {
+	if d.Policy != nil {
+		if size := d.Policy.Grow(d.len, cap(d.dat), add); size > cap(d.dat) {
+			d.resize(d.quantize(size))
+		}
+		if d.len+add > cap(d.dat) {
+			panic("deque: CapacityPolicy.Grow returned too small a capacity")
+		}
+		return
+	}
 	if d.len+add > cap(d.dat) {
 		if d.Minsize <= 0 {
 			d.Minsize = DefaultSize
 		}
-		size := cap(d.dat)
-		if size == 0 {
-			size = d.Minsize
+		var size int
+		if d.GrowStep > 0 {
+			size = max(d.Minsize, cap(d.dat))
+			if size < d.len+add {
+				size += (d.len + add - size + d.GrowStep - 1) / d.GrowStep * d.GrowStep
+			}
+		} else if d.GrowBase > 0 {
+			size = growSize(d.GrowBase, d.len, 0, add)
+		} else {
+			size = growSize(d.Minsize, d.len, cap(d.dat), add)
 		}
-		for size < d.len+add {
-			size *= 2
+		if d.MaxResize > 0 && size > d.MaxResize {
+			limit := d.MaxResize
+			if cap(d.dat) >= d.MaxResize {
+				limit = cap(d.dat) + d.MaxResize
+			}
+			size = max(min(size, limit), d.len+add)
 		}
-		d.resize(size)
+		d.resize(d.quantize(size))
+	}
+}
+
+// Round size up with QuantizeCap, if set.
+func (d *Deque[T]) quantize(size int) int {
+	if d.QuantizeCap == nil {
+		return size
 	}
+	return max(d.QuantizeCap(size), size)
 }
 
+// Shrink the backing store if the sizing settings call for it.  This
+// is small enough to inline, so removals from a deque that never
+// shrinks pay only the checks.
 func (d *Deque[T]) shrink() {
-	if d.Shrink == ShrinkNever {
+	if d.Policy == nil && d.ShrinkDecider == nil && d.Shrink == ShrinkNever {
+		return
+	}
+	d.shrinkToTarget()
+}
+
+func (d *Deque[T]) shrinkToTarget() {
+	if d.suspended > 0 || d.pinned > 0 {
+		return
+	}
+	var size int
+	if d.Policy != nil {
+		size = d.Policy.Shrink(d.len, cap(d.dat), d.Minsize)
+		if size >= cap(d.dat) {
+			return
+		}
+	} else {
+		if d.ShrinkDecider != nil {
+			target, ok := d.ShrinkDecider(d.len, cap(d.dat), d.Minsize)
+			if !ok {
+				return
+			}
+			size = max(target, d.Minsize)
+			if size >= cap(d.dat) {
+				return
+			}
+		} else {
+			size = shrinkSize(d.Shrink, d.len, cap(d.dat), d.Minsize)
+		}
+		if size < d.ShrinkFloor {
+			size = min(d.ShrinkFloor, cap(d.dat))
+		}
+	}
+	if size < d.ReservedCap {
+		size = min(d.ReservedCap, cap(d.dat))
+	}
+	if size < cap(d.dat) {
+		size = min(d.quantize(size), cap(d.dat))
+	}
+	if size == cap(d.dat) || size < d.len {
+		return
+	}
+	d.resize(size)
+}
+
+// Call the watermark callbacks, if any, when len crosses a watermark.
+// This is small enough to inline, so mutators pay only the nil checks
+// when no callbacks are set.
+func (d *Deque[T]) watermark() {
+	if d.OnHighWatermark != nil || d.OnLowWatermark != nil {
+		d.crossWatermark()
+	}
+}
+
+func (d *Deque[T]) crossWatermark() {
+	low := d.LowWatermark
+	if low <= 0 {
+		low = d.HighWatermark
+	}
+	if !d.high && d.HighWatermark > 0 && d.len >= d.HighWatermark {
+		d.high = true
+		if d.OnHighWatermark != nil {
+			d.OnHighWatermark(d.len)
+		}
+	} else if d.high && d.len < low {
+		d.high = false
+		if d.OnLowWatermark != nil {
+			d.OnLowWatermark(d.len)
+		}
+	}
+}
+
+// Change Minsize, and if shrinking is enabled, shrink toward the new
+// value now rather than on the next removal.  Panic if n is less than 1.
+func (d *Deque[T]) SetMinsize(n int) {
+	if n < 1 {
+		panic("deque: SetMinsize less than 1")
+	}
+	d.Minsize = n
+	if cap(d.dat) > n {
+		d.shrink()
+	}
+}
+
+// Stop shrinking until a matching call to ResumeShrink, without
+// changing the Shrink setting.  Calls may nest.
+func (d *Deque[T]) SuspendShrink() {
+	d.suspended++
+}
+
+// Undo a call to SuspendShrink.  When no suspensions remain,
+// optionally shrink once.
+func (d *Deque[T]) ResumeShrink() {
+	if d.suspended == 0 {
 		return
 	}
-	if d.len > d.Minsize || cap(d.dat) == d.Minsize {
+	d.suspended--
+	d.shrink()
+}
+
+// Hold the capacity steady, preventing any shrink, until a matching
+// call to UnpinCapacity.  The Shrink setting is kept.  Calls may nest,
+// and are counted separately from SuspendShrink.
+func (d *Deque[T]) PinCapacity() {
+	d.pinned++
+}
+
+// Undo a call to PinCapacity.  When nothing else holds the capacity,
+// optionally shrink once.
+func (d *Deque[T]) UnpinCapacity() {
+	if d.pinned == 0 {
 		return
 	}
-	if d.Shrink == ShrinkAt20Pct && d.len*5 > cap(d.dat) {
+	d.pinned--
+	d.shrink()
+}
+
+// Panic if the deque is append-only, for operations that
+// add to the head or remove from the end.
+func (d *Deque[T]) fifo(op string) {
+	if d.AppendOnly {
+		panic("deque: " + op + " on append-only deque")
+	}
+}
+
+// Copy the sizing settings of src: Minsize, Shrink, ShrinkFloor,
+// ReservedCap, ShrinkDecider, GrowBase, GrowStep, MaxResize,
+// QuantizeCap, and Policy.  The values are not touched, but the deque
+// shrinks now if the new settings call for it.  It never grows until
+// more values are added.
+func (d *Deque[T]) CopyConfigFrom(src *Deque[T]) {
+	d.Minsize, d.Shrink, d.ShrinkFloor = src.Minsize, src.Shrink, src.ShrinkFloor
+	d.ReservedCap = src.ReservedCap
+	d.GrowBase, d.GrowStep, d.MaxResize = src.GrowBase, src.GrowStep, src.MaxResize
+	d.QuantizeCap = src.QuantizeCap
+	d.Policy, d.ShrinkDecider = src.Policy, src.ShrinkDecider
+	d.shrink()
+}
+
+// Map a logical position, counted from the head, to a slice index.
+func (d *Deque[T]) index(i int) int {
+	i += d.head
+	if i >= cap(d.dat) {
+		i -= cap(d.dat)
+	}
+	return i
+}
+
+// Recompute tail from head and len.
+func (d *Deque[T]) settail() {
+	d.tail = d.head + d.len
+	if d.tail >= cap(d.dat) {
+		d.tail -= cap(d.dat)
+	}
+	if d.tail == 0 {
+		d.tail = cap(d.dat)
+	}
+	d.tail--
+}
+
+// Return the values at logical positions [i, j) as up to two
+// subslices of the backing store, in order from the head.
+func (d *Deque[T]) segments(i, j int) ([]T, []T) {
+	if i >= j {
+		return nil, nil
+	}
+	s, e := d.index(i), d.head+j
+	if e <= cap(d.dat) {
+		return d.dat[s:e], nil
+	}
+	if s < d.head {
+		return d.dat[s : e-cap(d.dat)], nil
+	}
+	return d.dat[s:], d.dat[:e-cap(d.dat)]
+}
+
+// Move the values to the start of the backing store in place,
+// rotating it left by head with three reversals.
+func (d *Deque[T]) straighten() {
+	if d.head == 0 {
 		return
 	}
-	if d.Shrink == ShrinkIfEmpty && d.len > 0 {
+	slices.Reverse(d.dat[:d.head])
+	slices.Reverse(d.dat[d.head:])
+	slices.Reverse(d.dat)
+	if d.seqon {
+		slices.Reverse(d.seq[:d.head])
+		slices.Reverse(d.seq[d.head:])
+		slices.Reverse(d.seq)
+	}
+	d.head = 0
+	d.settail()
+}
+
+// Tag the value at slice index i with the next sequence number.
+func (d *Deque[T]) tag(i int) {
+	if d.seqon {
+		d.seq[i] = d.nextseq
+		d.nextseq++
+	}
+}
+
+// Start a new sequence ring and tag every value, head to tail.
+func (d *Deque[T]) retag() {
+	if d.seqon {
+		d.seq = make([]uint64, cap(d.dat))
+		for i := 0; i < d.len; i++ {
+			d.tag(d.index(i))
+		}
+	}
+}
+
+// Copy the sequence numbers into a new ring of the given size, with
+// the head at the given index.  Called before a resize changes head.
+func (d *Deque[T]) relocateSeq(size, head int) {
+	if d.seqon {
+		tmp := make([]uint64, size)
+		for i := 0; i < d.len; i++ {
+			tmp[head+i] = d.seq[d.index(i)]
+		}
+		d.seq = tmp
+	}
+}
+
+// Start tagging values with sequence numbers, kept in a ring
+// parallel to the backing store, in the order they are added.
+// Values already present are numbered first, from head to tail.
+// Values keep their numbers as they move within the deque.
+func (d *Deque[T]) TrackSeq() {
+	if !d.seqon {
+		d.seqon = true
+		d.retag()
+	}
+}
+
+// Return the sequence number of the value at position i, counted
+// from the head.  When i is out of range, or sequence numbers are
+// not tracked, return 0 and false.
+func (d *Deque[T]) SeqAt(i int) (uint64, bool) {
+	if !d.seqon || i < 0 || i >= d.len {
+		return 0, false
+	}
+	return d.seq[d.index(i)], true
+}
+
+// Clear a vacated slot so it no longer holds a reference.
+func (d *Deque[T]) wipe(i int) {
+	if d.ZeroSet {
+		d.dat[i] = d.ZeroValue
 		return
 	}
-	d.resize(d.Minsize)
+	var zero T
+	d.dat[i] = zero
+}
+
+// Pack the values for which keep returns true toward the head,
+// preserving their order, and clear the vacated slots.  Return
+// the count removed.
+func (d *Deque[T]) compact(keep func(i int, v T) bool) int {
+	n := 0
+	for i := 0; i < d.len; i++ {
+		v := d.dat[d.index(i)]
+		if keep(i, v) {
+			d.dat[d.index(n)] = v
+			if d.seqon {
+				d.seq[d.index(n)] = d.seq[d.index(i)]
+			}
+			n++
+		}
+	}
+	removed := d.len - n
+	for i := n; i < d.len; i++ {
+		d.wipe(d.index(i))
+	}
+	d.len = n
+	d.settail()
+	return removed
+}
+
+// Move the head value to the end - only called when not full.
+func (d *Deque[T]) headToTail() {
+	h := d.head
+	v := d.dat[h]
+	d.wipe(h)
+	d.len--
+	d.head = d.index(1)
+	d.push(v)
+	if d.seqon {
+		d.seq[d.tail] = d.seq[h]
+	}
+}
+
+// Move the end value to the head, reusing the slot it frees.
+func (d *Deque[T]) tailToHead() {
+	t := d.tail
+	v := d.dat[t]
+	d.wipe(t)
+	d.len--
+	d.settail()
+	d.unshift(v)
+	if d.seqon {
+		d.seq[d.head] = d.seq[t]
+	}
 }
 
 // Push a single value - only called after grow().
@@ -99,7 +595,103 @@ func (d *Deque[T]) Push(v ...T) {
 	d.grow(len(v))
 	for _, x := range v {
 		d.push(x)
+		d.tag(d.tail)
+	}
+	d.watermark()
+}
+
+// A BulkLoader appends chunks of values to a deque whose room was
+// reserved up front by BulkLoad.
+type BulkLoader[T any] struct {
+	d *Deque[T]
+}
+
+// Make room for expected more values, growing at most once, and return
+// a loader to enqueue them in chunks.  Chunks past the reservation grow
+// the deque as Push would.
+func (d *Deque[T]) BulkLoad(expected int) *BulkLoader[T] {
+	d.grow(max(expected, 0))
+	return &BulkLoader[T]{d}
+}
+
+// Enqueue the values of s onto the end of the deque.
+// Panic if called after Done.
+func (b *BulkLoader[T]) Add(s []T) {
+	d := b.d
+	if d == nil {
+		panic("deque: BulkLoader.Add after Done")
+	}
+	d.grow(len(s))
+	for _, x := range s {
+		d.push(x)
+		d.tag(d.tail)
+	}
+}
+
+// Finish loading and check the watermarks once.
+func (b *BulkLoader[T]) Done() {
+	if b.d != nil {
+		b.d.watermark()
+		b.d = nil
+	}
+}
+
+// Replace the values of the deque with those of s, in order, clearing
+// the old slots.  The backing store is reused when large enough, and
+// otherwise grown once.  Settings are unchanged.
+func (d *Deque[T]) ReplaceAll(s []T) {
+	for i := 0; i < d.len; i++ {
+		d.wipe(d.index(i))
+	}
+	d.len = 0
+	d.grow(len(s))
+	d.head = 0
+	d.len = copy(d.dat, s)
+	d.settail()
+	for i := range s {
+		d.tag(i)
+	}
+	d.watermark()
+}
+
+// Replace the values of dst with those of the deque, in order,
+// clearing the old slots of dst.  The backing store of dst is reused
+// when large enough, and otherwise grown once, so the two never share
+// one.  Settings of dst are unchanged.
+func (d *Deque[T]) CloneInto(dst *Deque[T]) {
+	if dst == d {
+		return
 	}
+	for i := 0; i < dst.len; i++ {
+		dst.wipe(dst.index(i))
+	}
+	dst.len = 0
+	dst.grow(d.len)
+	dst.head = 0
+	a, b := d.segments(0, d.len)
+	dst.len = copy(dst.dat, a)
+	dst.len += copy(dst.dat[dst.len:], b)
+	dst.settail()
+	for i := 0; i < dst.len; i++ {
+		dst.tag(i)
+	}
+	dst.watermark()
+}
+
+// Enqueue values onto the end of the deque, and report
+// whether doing so allocated a larger backing store.
+func (d *Deque[T]) PushChecked(v ...T) (grew bool) {
+	size := cap(d.dat)
+	d.Push(v...)
+	return cap(d.dat) != size
+}
+
+// Enqueue values onto the end of the deque, and report whether
+// the deque then holds more than SoftCap values.  The push itself
+// succeeds regardless.
+func (d *Deque[T]) PushSoft(v ...T) (exceeded bool) {
+	d.Push(v...)
+	return d.SoftCap > 0 && d.len > d.SoftCap
 }
 
 // Unshift a single value - only called after grow().
@@ -114,23 +706,52 @@ func (d *Deque[T]) unshift(v T) {
 
 // Enqueue values onto the head of the deque.
 func (d *Deque[T]) Unshift(v ...T) {
+	d.fifo("Unshift")
 	d.grow(len(v))
 	for _, x := range v {
 		d.unshift(x)
+		d.tag(d.head)
 	}
+	d.watermark()
+}
+
+// Enqueue the values of s onto the head of the deque, keeping their
+// order, so the deque then reads s followed by its prior values.
+// Unlike Unshift, this makes room once and copies s with at most two
+// calls to copy, one each side of the wraparound.
+func (d *Deque[T]) PrependSlice(s []T) {
+	d.fifo("PrependSlice")
+	if len(s) == 0 {
+		return
+	}
+	d.grow(len(s))
+	d.head -= len(s)
+	if d.head < 0 {
+		d.head += cap(d.dat)
+	}
+	d.len += len(s)
+	a, b := d.segments(0, len(s))
+	copy(b, s[copy(a, s):])
+	for i := range s {
+		d.tag(d.index(i))
+	}
+	d.watermark()
 }
 
 // Return and remove a single value from the end of the deque,
 // and optionally shrink.  When empty, return a zero value and false.
 func (d *Deque[T]) Pop() (v T, ok bool) {
+	d.fifo("Pop")
 	if d.len > 0 {
 		d.len--
 		v, ok = d.dat[d.tail], true
+		d.wipe(d.tail)
 		if d.tail == 0 {
 			d.tail = cap(d.dat)
 		}
 		d.tail--
 		d.shrink()
+		d.watermark()
 	}
 	return
 }
@@ -141,15 +762,222 @@ func (d *Deque[T]) Shift() (v T, ok bool) {
 	if d.len > 0 {
 		d.len--
 		v, ok = d.dat[d.head], true
+		d.wipe(d.head)
 		d.head++
 		if d.head == cap(d.dat) {
 			d.head = 0
 		}
 		d.shrink()
+		d.watermark()
 	}
 	return
 }
 
+// Return and remove values from the head of the deque while their
+// total sizeOf stays within maxBytes, and optionally shrink.  When not
+// empty, at least one value is returned, even if it alone is too large.
+func (d *Deque[T]) ShiftUntilSize(maxBytes int, sizeOf func(T) int) []T {
+	n, total := 0, 0
+	for ; n < d.len; n++ {
+		size := sizeOf(d.dat[d.index(n)])
+		if n > 0 && total+size > maxBytes {
+			break
+		}
+		total += size
+	}
+	s := make([]T, n)
+	d.PeekN(n, s)
+	d.Discard(n)
+	return s
+}
+
+// Remove up to n values from the head of the deque without returning
+// them, and optionally shrink.  Return the count removed.
+func (d *Deque[T]) Discard(n int) int {
+	if n > d.len {
+		n = d.len
+	}
+	if n <= 0 {
+		return 0
+	}
+	for i := 0; i < n; i++ {
+		d.wipe(d.index(i))
+	}
+	d.head = d.index(n)
+	d.len -= n
+	d.settail()
+	d.shrink()
+	d.watermark()
+	return n
+}
+
+// Remove up to n values from the end of the deque without returning
+// them, and optionally shrink.  Return the count removed.
+func (d *Deque[T]) DiscardBack(n int) int {
+	d.fifo("DiscardBack")
+	if n > d.len {
+		n = d.len
+	}
+	if n <= 0 {
+		return 0
+	}
+	for i := d.len - n; i < d.len; i++ {
+		d.wipe(d.index(i))
+	}
+	d.len -= n
+	d.settail()
+	d.shrink()
+	d.watermark()
+	return n
+}
+
+// Move up to n values from the end of the deque onto the head of dst,
+// keeping their order, and optionally shrink.  Return the count moved.
+func (d *Deque[T]) TransferTailToHead(dst *Deque[T], n int) int {
+	d.fifo("TransferTailToHead")
+	dst.fifo("TransferTailToHead")
+	n = min(n, d.len)
+	if n <= 0 {
+		return 0
+	}
+	if dst == d {
+		for i := 0; i < n; i++ {
+			d.tailToHead()
+		}
+		return n
+	}
+	dst.grow(n)
+	for i := d.len - 1; i >= d.len-n; i-- {
+		j := d.index(i)
+		dst.unshift(d.dat[j])
+		dst.tag(dst.head)
+		d.wipe(j)
+	}
+	d.len -= n
+	d.settail()
+	d.shrink()
+	d.watermark()
+	dst.watermark()
+	return n
+}
+
+// Rotate the deque so the value at position i, counted from the head,
+// becomes the head.  Out of range positions are ignored.  A full deque
+// rotates in O(1) by moving the head; otherwise the values on the
+// shorter side of i move across the gap, one at a time.
+func (d *Deque[T]) RotateToIndex(i int) {
+	if i <= 0 || i >= d.len {
+		return
+	}
+	if d.len == cap(d.dat) {
+		d.head = d.index(i)
+		d.settail()
+		return
+	}
+	if i <= d.len-i {
+		for ; i > 0; i-- {
+			d.headToTail()
+		}
+		return
+	}
+	for i = d.len - i; i > 0; i-- {
+		d.tailToHead()
+	}
+}
+
+// Insert v at position i, counted from the head, so the values from i
+// on follow it.  Whichever side of i is shorter shifts by one, so the
+// cost is O(min(i, len-i)) moves.  Panic if i is outside [0, Len()],
+// or if the deque is AppendOnly and i is not Len().
+func (d *Deque[T]) InsertAtFast(i int, v T) {
+	if i < 0 || i > d.len {
+		panic("deque: InsertAtFast index out of range")
+	}
+	if i < d.len {
+		d.fifo("InsertAtFast")
+	}
+	d.grow(1)
+	if i < d.len-i {
+		d.unshift(d.dat[d.head])
+		for k := 0; k < i; k++ {
+			d.move(d.index(k+1), d.index(k))
+		}
+	} else {
+		var zero T
+		d.push(zero)
+		for k := d.len - 1; k > i; k-- {
+			d.move(d.index(k-1), d.index(k))
+		}
+	}
+	d.dat[d.index(i)] = v
+	d.tag(d.index(i))
+	d.watermark()
+}
+
+// Copy the value and sequence number at slot src to slot dst.
+func (d *Deque[T]) move(src, dst int) {
+	d.dat[dst] = d.dat[src]
+	if d.seqon {
+		d.seq[dst] = d.seq[src]
+	}
+}
+
+// Rotate the deque so its smallest value by less, the first among
+// equals, becomes the head, as RotateToIndex does.  Return false when
+// empty.
+func (d *Deque[T]) RotateToMin(less func(a, b T) bool) bool {
+	i := d.IndexOfMin(less)
+	if i < 0 {
+		return false
+	}
+	d.RotateToIndex(i)
+	return true
+}
+
+// Rotate the deque left by n, so the value at position n becomes the
+// head, or right for negative n, and then move the values in place to
+// start at index 0 of the backing store.  This trades an upfront pass
+// for a ToSlice that needn't copy.
+func (d *Deque[T]) RotateAndCompact(n int) {
+	if d.len == 0 {
+		return
+	}
+	n %= d.len
+	if n < 0 {
+		n += d.len
+	}
+	d.RotateToIndex(n)
+	d.straighten()
+}
+
+// Shuffle the values in place with a Fisher-Yates shuffle drawing
+// from r, so a seeded source gives a reproducible order.
+func (d *Deque[T]) ShuffleRand(r *rand.Rand) {
+	for i := d.len - 1; i > 0; i-- {
+		a, b := d.index(i), d.index(r.IntN(i+1))
+		d.dat[a], d.dat[b] = d.dat[b], d.dat[a]
+		if d.seqon {
+			d.seq[a], d.seq[b] = d.seq[b], d.seq[a]
+		}
+	}
+}
+
+// Reverse the values at positions [i, j), counted from the head, in
+// place.  ReverseRange(0, d.Len()) reverses the whole deque.  Out of
+// range bounds, or i >= j, do nothing.
+func (d *Deque[T]) ReverseRange(i, j int) {
+	if i < 0 || j > d.len || i >= j {
+		return
+	}
+	for j--; i < j; i, j = i+1, j-1 {
+		a, b := d.index(i), d.index(j)
+		d.dat[a], d.dat[b] = d.dat[b], d.dat[a]
+		if d.seqon {
+			d.seq[a], d.seq[b] = d.seq[b], d.seq[a]
+		}
+	}
+}
+
 // Return a single value from the end of the deque.
 // When empty, return a zero value and false.
 func (d *Deque[T]) Peek() (v T, ok bool) {
@@ -168,6 +996,384 @@ func (d *Deque[T]) PeekShift() (v T, ok bool) {
 	return
 }
 
+// Return the value at position i, counted from the head.
+// When out of range, return a zero value and false.
+func (d *Deque[T]) At(i int) (v T, ok bool) {
+	if i >= 0 && i < d.len {
+		v, ok = d.dat[d.index(i)], true
+	}
+	return
+}
+
+// Replace the value at position i, counted from the head, with v.
+// Return false if i is out of range.
+func (d *Deque[T]) Set(i int, v T) bool {
+	if i < 0 || i >= d.len {
+		return false
+	}
+	d.dat[d.index(i)] = v
+	return true
+}
+
+// Copy up to n values from the head of the deque into dst, without
+// removing them.  Return the count copied, which is limited by
+// the length of the deque and of dst.
+func (d *Deque[T]) PeekN(n int, dst []T) int {
+	if n > d.len {
+		n = d.len
+	}
+	if n > len(dst) {
+		n = len(dst)
+	}
+	a, b := d.segments(0, n)
+	return copy(dst[copy(dst, a):], b) + len(a)
+}
+
+// Return a new slice holding every step-th value, starting with the
+// head.  A step less than 1 is treated as 1.
+func (d *Deque[T]) Sample(step int) []T {
+	if step < 1 {
+		step = 1
+	}
+	s := make([]T, 0, (d.len+step-1)/step)
+	for i := 0; i < d.len; i += step {
+		s = append(s, d.dat[d.index(i)])
+	}
+	return s
+}
+
+// Iterate over the values from head to tail, leaving the deque
+// unchanged.  The deque must not be modified during the loop; see
+// AllSnapshot for a loop that pushes.
+func (d *Deque[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for i := 0; i < d.len; i++ {
+			if !yield(d.dat[d.index(i)]) {
+				return
+			}
+		}
+	}
+}
+
+// Iterate over the values present when the loop starts, from head to
+// tail.  Values pushed onto the end during the loop are not yielded,
+// even if the deque grows.  Removing or unshifting values during the
+// loop shifts positions, so some values may be skipped or repeated.
+func (d *Deque[T]) AllSnapshot() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		n := d.len
+		for i := 0; i < n && i < d.len; i++ {
+			if !yield(d.dat[d.index(i)]) {
+				return
+			}
+		}
+	}
+}
+
+// Iterate over values popped from the end of the deque until it is
+// empty.  If the loop stops early, the values not yet yielded remain.
+// Shrinking is suspended until the loop ends.
+func (d *Deque[T]) DrainBackward() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		d.SuspendShrink()
+		defer d.ResumeShrink()
+		for {
+			v, ok := d.Pop()
+			if !ok || !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// A handle on one value during Scan.
+type Entry[T any] struct {
+	v    T
+	mark *bool
+}
+
+// Return the value.
+func (e Entry[T]) Value() T {
+	return e.v
+}
+
+// Mark the value for removal once the scan ends.
+func (e Entry[T]) Remove() {
+	*e.mark = true
+}
+
+// Return a sequence of entries from head to tail.  Values marked with
+// Entry.Remove are removed together when the scan ends, even if it ends
+// early, keeping the order of the rest, and the deque optionally
+// shrinks.  The deque must not be modified during the scan.
+func (d *Deque[T]) Scan() iter.Seq[Entry[T]] {
+	return func(yield func(Entry[T]) bool) {
+		marks := make([]bool, d.len)
+		for i := range marks {
+			if !yield(Entry[T]{d.dat[d.index(i)], &marks[i]}) {
+				break
+			}
+		}
+		if d.compact(func(i int, _ T) bool { return !marks[i] }) > 0 {
+			d.shrink()
+			d.watermark()
+		}
+	}
+}
+
+// Iterate over the positions in [from, to), clamped to the deque,
+// yielding each position, counted from the head, and its value.
+func (d *Deque[T]) RangeIndices(from, to int) iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		for i := max(from, 0); i < min(to, d.len); i++ {
+			if !yield(i, d.dat[d.index(i)]) {
+				return
+			}
+		}
+	}
+}
+
+// Iterate from position start, counted from the head, moving step
+// positions at a time, backward when step is negative, until leaving
+// the deque.  Yield each position and its value.  A step of zero, or a
+// start outside the deque, yields nothing.
+func (d *Deque[T]) Stride(start, step int) iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		if step == 0 {
+			return
+		}
+		for i := start; i >= 0 && i < d.len; i += step {
+			if !yield(i, d.dat[d.index(i)]) {
+				return
+			}
+		}
+	}
+}
+
+// Iterate over the deque in chunks of up to k values from head to
+// tail, each a new slice, leaving the deque unchanged.  The last chunk
+// may be shorter.  A k less than 1 is treated as 1.
+func (d *Deque[T]) Chunks(k int) iter.Seq[[]T] {
+	if k < 1 {
+		k = 1
+	}
+	return func(yield func([]T) bool) {
+		for i := 0; i < d.len; i += k {
+			n := min(k, d.len-i)
+			a, b := d.segments(i, i+n)
+			c := make([]T, n)
+			copy(c[copy(c, a):], b)
+			if !yield(c) {
+				return
+			}
+		}
+	}
+}
+
+// Iterate over chunks of up to k values removed from the head, each a
+// new slice, until the deque is empty.  If the loop stops early, the
+// values not yet yielded remain.  A k less than 1 is treated as 1.
+// Shrinking is suspended until the loop ends.
+func (d *Deque[T]) DrainChunks(k int) iter.Seq[[]T] {
+	if k < 1 {
+		k = 1
+	}
+	return func(yield func([]T) bool) {
+		d.SuspendShrink()
+		defer d.ResumeShrink()
+		for d.len > 0 {
+			c := make([]T, min(k, d.len))
+			d.PeekN(len(c), c)
+			d.Discard(len(c))
+			if !yield(c) {
+				return
+			}
+		}
+	}
+}
+
+// Remove every value from the head and send them to ch in batches of
+// up to k, each a new slice, so the last may be shorter.  A k less than
+// 1 is treated as 1.  This blocks while ch is full, so the deque must
+// not be shared with the receiver.
+func (d *Deque[T]) ShiftToChannelBatched(ch chan<- []T, k int) {
+	for c := range d.DrainChunks(k) {
+		ch <- c
+	}
+}
+
+// Count the values from the head for which pred returns true,
+// stopping at the first for which it does not.
+func (d *Deque[T]) RunLength(pred func(T) bool) int {
+	n := 0
+	for n < d.len && pred(d.dat[d.index(n)]) {
+		n++
+	}
+	return n
+}
+
+// Return the length of the longest run of consecutive values
+// for which pred returns true.
+func (d *Deque[T]) MaxRun(pred func(T) bool) int {
+	best, n := 0, 0
+	for i := 0; i < d.len; i++ {
+		if pred(d.dat[d.index(i)]) {
+			n++
+			best = max(best, n)
+		} else {
+			n = 0
+		}
+	}
+	return best
+}
+
+// Report whether each value is strictly greater, by less, than the one
+// before it, from head to tail.
+func (d *Deque[T]) IsMonotonic(less func(a, b T) bool) bool {
+	for i := 1; i < d.len; i++ {
+		if !less(d.dat[d.index(i-1)], d.dat[d.index(i)]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Report whether no value is less, by less, than the one before it,
+// from head to tail.
+func (d *Deque[T]) IsSorted(less func(a, b T) bool) bool {
+	for i := 1; i < d.len; i++ {
+		if less(d.dat[d.index(i)], d.dat[d.index(i-1)]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the position, counted from the head, of the smallest value
+// by less, or -1 when empty.  Among equals, the first wins.
+func (d *Deque[T]) IndexOfMin(less func(a, b T) bool) int {
+	if d.len == 0 {
+		return -1
+	}
+	m := 0
+	for i := 1; i < d.len; i++ {
+		if less(d.dat[d.index(i)], d.dat[d.index(m)]) {
+			m = i
+		}
+	}
+	return m
+}
+
+// Return the position, counted from the head, of the largest value
+// by less, or -1 when empty.  Among equals, the first wins.
+func (d *Deque[T]) IndexOfMax(less func(a, b T) bool) int {
+	if d.len == 0 {
+		return -1
+	}
+	m := 0
+	for i := 1; i < d.len; i++ {
+		if less(d.dat[d.index(m)], d.dat[d.index(i)]) {
+			m = i
+		}
+	}
+	return m
+}
+
+// Return the positions, counted from the head, of the values for
+// which isPresent returns true, in order.
+func (d *Deque[T]) PresentIndices(isPresent func(T) bool) []int {
+	var s []int
+	for i := 0; i < d.len; i++ {
+		if isPresent(d.dat[d.index(i)]) {
+			s = append(s, i)
+		}
+	}
+	return s
+}
+
+// Count the values for which isPresent returns true.
+func (d *Deque[T]) CountPresent(isPresent func(T) bool) int {
+	n := 0
+	for i := 0; i < d.len; i++ {
+		if isPresent(d.dat[d.index(i)]) {
+			n++
+		}
+	}
+	return n
+}
+
+// Replace each value, from head to tail, with the result of fn
+// called with its position, counted from the head, and the value.
+func (d *Deque[T]) Update(fn func(i int, v T) T) {
+	for i := 0; i < d.len; i++ {
+		j := d.index(i)
+		d.dat[j] = fn(i, d.dat[j])
+	}
+}
+
+// Replace the first value, from the head, for which match returns
+// true with the result of update called with it.  Return false if no
+// value matches.
+func (d *Deque[T]) UpdateFirst(match func(T) bool, update func(T) T) bool {
+	for i := 0; i < d.len; i++ {
+		j := d.index(i)
+		if match(d.dat[j]) {
+			d.dat[j] = update(d.dat[j])
+			return true
+		}
+	}
+	return false
+}
+
+// Return the values at positions [i, j), counted from the head and
+// clamped to the deque, as up to two subslices of the backing store,
+// in order.  The second is empty unless the range wraps around.  The
+// subslices alias the deque, so any change to it may invalidate them.
+func (d *Deque[T]) PeekRangeSegments(i, j int) ([]T, []T) {
+	return d.segments(max(i, 0), min(j, d.len))
+}
+
+// Copy up to the last n values of the deque into dst, in order from
+// head to tail, without removing them.  Return the count copied,
+// which is limited by the length of the deque and of dst.
+func (d *Deque[T]) LastInto(n int, dst []T) int {
+	n = min(n, d.len, len(dst))
+	a, b := d.segments(d.len-n, d.len)
+	return copy(dst[copy(dst, a):], b) + len(a)
+}
+
+// Render the whole backing store in index order, for debugging.
+// Unused slots appear as _, and the head and tail slots are prefixed
+// with H: and T:, so [4, T:5, _, H:2, 3] is a deque of 2, 3, 4, 5
+// wrapping around the end of the store.  The tail of an empty deque
+// is the slot before the head.
+func (d *Deque[T]) DebugDump() string {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i := range d.dat {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		if i == d.head {
+			sb.WriteString("H")
+		}
+		if i == d.tail {
+			sb.WriteString("T")
+		}
+		if i == d.head || i == d.tail {
+			sb.WriteString(":")
+		}
+		// slot i is in use when its distance from head is under len
+		if (i-d.head+cap(d.dat))%cap(d.dat) < d.len {
+			fmt.Fprint(&sb, d.dat[i])
+		} else {
+			sb.WriteString("_")
+		}
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
+
 // Length of the deque
 func (d *Deque[T]) Len() int {
 	return d.len
@@ -178,6 +1384,43 @@ func (d *Deque[T]) Cap() int {
 	return cap(d.dat)
 }
 
+// Index of the head value in the backing store, for interop with
+// code that understands the ring layout.
+func (d *Deque[T]) Head() int {
+	return d.head
+}
+
+// Index of the tail value in the backing store.  When empty,
+// this is the slot before the head.
+func (d *Deque[T]) Tail() int {
+	return d.tail
+}
+
+// Return the entire backing store, including unused slots.  Writing
+// through it bypasses the deque's bookkeeping, and the next resize
+// replaces it, so use it only to describe the ring to other code.
+func (d *Deque[T]) Backing() []T {
+	return d.dat
+}
+
+// Count of unused slots immediately before the head in the backing
+// store.  When the deque wraps, this is the gap between tail and head.
+func (d *Deque[T]) FreeHead() int {
+	if d.head+d.len > cap(d.dat) {
+		return cap(d.dat) - d.len
+	}
+	return d.head
+}
+
+// Count of unused slots immediately after the tail in the backing
+// store.  When the deque wraps, this is the gap between tail and head.
+func (d *Deque[T]) FreeTail() int {
+	if d.head+d.len > cap(d.dat) {
+		return cap(d.dat) - d.len
+	}
+	return cap(d.dat) - d.head - d.len
+}
+
 // Return a slice of the deque arranged with head equal to 0.
 func (d *Deque[T]) ToSlice() []T {
 	if d.len == 0 {
@@ -189,10 +1432,82 @@ func (d *Deque[T]) ToSlice() []T {
 	return d.dat[:d.len]
 }
 
+// Copy the deque into dst, reusing its storage when it has enough
+// capacity, and return the result.  Unlike ToSlice, this never
+// rearranges the deque.
+func (d *Deque[T]) ToSliceInto(dst []T) []T {
+	if cap(dst) < d.len {
+		dst = make([]T, d.len)
+	}
+	dst = dst[:d.len]
+	a, b := d.segments(0, d.len)
+	copy(dst[copy(dst, a):], b)
+	return dst
+}
+
+// Move the values so the free space is split evenly before the head
+// and after the tail, without changing length or capacity.  This is
+// purely an optimization hint, worthwhile when the end that grows is
+// about to change.  The values are moved in place, so nothing is
+// allocated and an adopted slice stays adopted.
+func (d *Deque[T]) Rebalance() {
+	head := (cap(d.dat) - d.len) / 2
+	d.straighten()
+	copy(d.dat[head:], d.dat[:d.len])
+	if d.seqon {
+		copy(d.seq[head:], d.seq[:d.len])
+	}
+	for i := 0; i < min(head, d.len); i++ {
+		d.wipe(i)
+	}
+	d.head = head
+	d.settail()
+}
+
+// Move the values in place to start at index 0 of the backing store,
+// so Backing()[:Len()] holds them in order.  Neither length nor
+// capacity changes, and nothing is allocated.
+func (d *Deque[T]) MakeContiguous() {
+	d.straighten()
+}
+
+// Return the values as a slice of the backing store, first moving
+// them in place to start at index 0, and empty the deque without
+// changing its settings.  The caller takes ownership of the backing
+// array; the deque no longer refers to it.
+func (d *Deque[T]) TakeSlice() []T {
+	d.straighten()
+	s := d.dat[:d.len]
+	d.dat, d.seq, d.adopted = nil, nil, false
+	d.head, d.tail, d.len = 0, 0, 0
+	d.watermark()
+	return s
+}
+
+// Return the values as a slice without rearranging the deque.  When
+// the values are contiguous in the backing store, the result aliases
+// it, so writes to one appear in the other, and ok is true.  Otherwise
+// the result is a new copy and ok is false.
+func (d *Deque[T]) ToSlicePreferNoCopy() (s []T, ok bool) {
+	a, b := d.segments(0, d.len)
+	if a != nil && b == nil {
+		return a[:len(a):len(a)], true
+	}
+	return d.ToSliceInto([]T{}), false
+}
+
 // Use a provided slice as the initial backing store for the deque.
 // The next resize() will replace the slice.
 func (d *Deque[T]) WrapSlice(dat []T) {
-	d.dat = dat[:cap(dat)]
+	d.wrap(dat)
+	d.adopted = true
+}
+
+// Use dat as the backing store, holding len(dat) values from index 0.
+// Package functions wrap the slices they build with this, so their
+// results do not report an adopted slice.
+func (d *Deque[T]) wrap(dat []T) {
+	d.dat, d.adopted = dat[:cap(dat)], false
 	d.len = len(dat)
 	d.head = 0
 	d.tail = d.len
@@ -200,4 +1515,538 @@ func (d *Deque[T]) WrapSlice(dat []T) {
 		d.tail = cap(d.dat)
 	}
 	d.tail--
+	d.retag()
+}
+
+// Use a provided slice as the initial backing store for the deque,
+// treating it as a ring holding length values starting at index head.
+// Panic if head or length is out of range for the capacity of dat.
+func (d *Deque[T]) WrapRing(dat []T, head, length int) {
+	if length < 0 || length > cap(dat) {
+		panic("deque: WrapRing length out of range")
+	}
+	if head < 0 || head > 0 && head >= cap(dat) {
+		panic("deque: WrapRing head out of range")
+	}
+	d.dat, d.adopted = dat[:cap(dat)], true
+	d.len = length
+	d.head = head
+	d.settail()
+	d.retag()
+}
+
+// Replace the backing store with dat, which the caller guarantees
+// holds the same values at the same positions, keeping head, tail,
+// and length.  Nothing is copied.  Panic if the capacity of dat
+// differs from the current capacity.
+func (d *Deque[T]) Rebind(dat []T) {
+	if cap(dat) != cap(d.dat) {
+		panic("deque: Rebind capacity mismatch")
+	}
+	d.dat, d.adopted = dat[:cap(dat)], true
+}
+
+// Repair inconsistent bookkeeping, such as a length exceeding the
+// capacity after adopting a slice from an untrusted source, and report
+// whether any repair was needed.  An out of range head is reset to 0,
+// and the length is clamped to the capacity, which may drop values.
+func (d *Deque[T]) Normalize() bool {
+	head, tail, length := d.head, d.tail, d.len
+	if cap(d.dat) == 0 {
+		// tail is reset by the first grow
+		d.head, d.len = 0, 0
+		return d.head != head || d.len != length
+	}
+	if d.head < 0 || d.head >= cap(d.dat) {
+		d.head = 0
+	}
+	d.len = min(max(d.len, 0), cap(d.dat))
+	d.settail()
+	return d.head != head || d.tail != tail || d.len != length
+}
+
+// Report whether the backing store is still the slice the caller
+// provided to WrapSlice, WrapRing, or Rebind, so that the caller's
+// slice and the deque alias each other.  Any resize ends this.
+func (d *Deque[T]) OwnsBackingArray() bool {
+	return d.adopted
+}
+
+// Remove every value for which pred returns true, keeping the rest
+// in order, and optionally shrink.  Return the count removed.
+func (d *Deque[T]) RemoveAll(pred func(T) bool) int {
+	n := d.compact(func(_ int, v T) bool { return !pred(v) })
+	if n > 0 {
+		d.shrink()
+		d.watermark()
+	}
+	return n
+}
+
+// Replace each run of consecutive values that eq reports equal with
+// the first of the run, keeping the rest in order, and optionally
+// shrink.  Return the count removed.
+func (d *Deque[T]) CompactDuplicates(eq func(a, b T) bool) int {
+	var last T
+	n := d.compact(func(i int, v T) bool {
+		if i > 0 && eq(last, v) {
+			return false
+		}
+		last = v
+		return true
+	})
+	if n > 0 {
+		d.shrink()
+		d.watermark()
+	}
+	return n
+}
+
+// Merge adjacent values from head to tail: whenever combine reports
+// that a value merges with the one before it, the pair is replaced by
+// the merged value, which may then merge with the next.  The rest keep
+// their order, and the deque optionally shrinks.  Return the count of
+// merges.
+func (d *Deque[T]) CoalesceAdjacent(combine func(a, b T) (T, bool)) int {
+	k, last := 0, 0
+	n := d.compact(func(_ int, v T) bool {
+		if k > 0 {
+			if m, ok := combine(d.dat[last], v); ok {
+				d.dat[last] = m
+				return false
+			}
+		}
+		last = d.index(k)
+		k++
+		return true
+	})
+	if n > 0 {
+		d.shrink()
+		d.watermark()
+	}
+	return n
+}
+
+// Remove the values at the given positions, counted from the head,
+// which must be sorted ascending, keeping the rest in order, and
+// optionally shrink.  Return the count removed.  Out of range
+// positions, and any not above the one before, are ignored.
+func (d *Deque[T]) RemoveIndices(sorted []int) int {
+	k := 0
+	n := d.compact(func(i int, _ T) bool {
+		for k < len(sorted) && sorted[k] < i {
+			k++
+		}
+		return k == len(sorted) || sorted[k] != i
+	})
+	if n > 0 {
+		d.shrink()
+		d.watermark()
+	}
+	return n
+}
+
+// Keep only the values at the given positions, counted from the head,
+// in their original order, and optionally shrink.  Duplicate and out
+// of range positions are ignored.
+func (d *Deque[T]) Retain(indices []int) {
+	keep := make([]bool, d.len)
+	for _, i := range indices {
+		if i >= 0 && i < d.len {
+			keep[i] = true
+		}
+	}
+	if d.compact(func(i int, _ T) bool { return keep[i] }) > 0 {
+		d.shrink()
+		d.watermark()
+	}
+}
+
+// Merge two deques, each sorted by less, into a new sorted deque.
+// Neither input is modified.  Values from a come first among equals.
+func MergeSorted[T any](a, b *Deque[T], less func(x, y T) bool) *Deque[T] {
+	s := make([]T, 0, a.len+b.len)
+	i, j := 0, 0
+	for i < a.len && j < b.len {
+		x, y := a.dat[a.index(i)], b.dat[b.index(j)]
+		if less(y, x) {
+			s = append(s, y)
+			j++
+		} else {
+			s = append(s, x)
+			i++
+		}
+	}
+	for ; i < a.len; i++ {
+		s = append(s, a.dat[a.index(i)])
+	}
+	for ; j < b.len; j++ {
+		s = append(s, b.dat[b.index(j)])
+	}
+	d := &Deque[T]{}
+	d.wrap(s)
+	return d
+}
+
+// Report whether the deque holds the same values as s, in order
+// from the head, comparing each pair with eq.
+func (d *Deque[T]) EqualSliceFunc(s []T, eq func(a, b T) bool) bool {
+	if d.len != len(s) {
+		return false
+	}
+	for i, v := range s {
+		if !eq(d.dat[d.index(i)], v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Report whether a and b have the same backing array, as after
+// wrapping one slice in both.  Slices of an array end at the same
+// element when extended to their capacity, so compare those.
+func SharesBacking[T any](a, b *Deque[T]) bool {
+	if cap(a.dat) == 0 || cap(b.dat) == 0 {
+		return false
+	}
+	return &a.dat[:cap(a.dat)][cap(a.dat)-1] == &b.dat[:cap(b.dat)][cap(b.dat)-1]
+}
+
+// Report whether the deque holds the same values as s, in order
+// from the head.  Methods cannot add constraints, so this is a function.
+func EqualSlice[T comparable](d *Deque[T], s []T) bool {
+	return d.EqualSliceFunc(s, func(a, b T) bool { return a == b })
+}
+
+// Enqueue v onto the end of the deque unless a value with the same key
+// is already present, and report whether v was enqueued.  This scans
+// the deque, costing O(n); for large deques, track keys in a set.
+func PushIfAbsent[T any, K comparable](d *Deque[T], v T, key func(T) K) bool {
+	k := key(v)
+	for i := 0; i < d.len; i++ {
+		if key(d.dat[d.index(i)]) == k {
+			return false
+		}
+	}
+	d.Push(v)
+	return true
+}
+
+// Remove every value equal to an earlier one, keeping the first of
+// each in order, and optionally shrink.  Return the count removed.
+func Dedup[T comparable](d *Deque[T]) int {
+	return DedupFunc(d, func(v T) T { return v })
+}
+
+// Remove every value whose key matches that of an earlier one, keeping
+// the first of each in order, and optionally shrink.  Return the count
+// removed.
+func DedupFunc[T any, K comparable](d *Deque[T], key func(T) K) int {
+	seen := make(map[K]struct{}, d.len)
+	n := d.compact(func(_ int, v T) bool {
+		k := key(v)
+		if _, ok := seen[k]; ok {
+			return false
+		}
+		seen[k] = struct{}{}
+		return true
+	})
+	if n > 0 {
+		d.shrink()
+		d.watermark()
+	}
+	return n
+}
+
+// Remove values from the head of a deque ordered by ascending
+// timestamp while ts of the value is before cutoff, and optionally
+// shrink.  Return the count removed.
+func EvictOlderThan[T any](d *Deque[T], cutoff int64, ts func(T) int64) int {
+	n := 0
+	for n < d.len && ts(d.dat[d.index(n)]) < cutoff {
+		n++
+	}
+	return d.Discard(n)
+}
+
+// Return a new deque holding the values of each part in turn, sized
+// to fit them all with a single allocation.  The parts are not modified.
+func Join[T any](parts ...*Deque[T]) *Deque[T] {
+	n := 0
+	for _, p := range parts {
+		n += p.len
+	}
+	s := make([]T, n)
+	n = 0
+	for _, p := range parts {
+		n += p.PeekN(p.len, s[n:])
+	}
+	d := &Deque[T]{}
+	d.wrap(s)
+	return d
+}
+
+// Pair holds one value from each of two deques.
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// Return a new deque pairing the values of a and b in order, up to
+// the shorter length.  Neither input is modified.
+func Zip[A, B any](a *Deque[A], b *Deque[B]) *Deque[Pair[A, B]] {
+	s := make([]Pair[A, B], min(a.len, b.len))
+	for i := range s {
+		s[i] = Pair[A, B]{a.dat[a.index(i)], b.dat[b.index(i)]}
+	}
+	d := &Deque[Pair[A, B]]{}
+	d.wrap(s)
+	return d
+}
+
+// Split a deque of pairs into new deques of the first and second
+// values, in order.  The input is not modified.
+func Unzip[A, B any](d *Deque[Pair[A, B]]) (*Deque[A], *Deque[B]) {
+	as, bs := make([]A, d.len), make([]B, d.len)
+	for i := range as {
+		p := d.dat[d.index(i)]
+		as[i], bs[i] = p.First, p.Second
+	}
+	a, b := &Deque[A]{}, &Deque[B]{}
+	a.wrap(as)
+	b.wrap(bs)
+	return a, b
+}
+
+// Return a new deque holding the values of each slice in the deque,
+// in order, sized to fit them all.  The source is not modified.
+func Flatten[T any](d *Deque[[]T]) *Deque[T] {
+	n := 0
+	for i := 0; i < d.len; i++ {
+		n += len(d.dat[d.index(i)])
+	}
+	s := make([]T, 0, n)
+	for i := 0; i < d.len; i++ {
+		s = append(s, d.dat[d.index(i)]...)
+	}
+	r := &Deque[T]{}
+	r.wrap(s)
+	return r
+}
+
+// Split a deque into new deques of the values for which pred returns
+// true and of the rest, each in their original order.  The source is
+// not modified.
+func Partition[T any](d *Deque[T], pred func(T) bool) (match, rest *Deque[T]) {
+	// Fill matches from the front and the rest from the back of a
+	// single allocation, then put the rest back in order.
+	s := make([]T, d.len)
+	m, r := 0, d.len
+	for i := 0; i < d.len; i++ {
+		if v := d.dat[d.index(i)]; pred(v) {
+			s[m] = v
+			m++
+		} else {
+			r--
+			s[r] = v
+		}
+	}
+	for i, j := m, d.len-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+	match, rest = &Deque[T]{}, &Deque[T]{}
+	match.wrap(s[:m:m])
+	rest.wrap(s[m:])
+	return
+}
+
+// Return a new deque holding conv applied to each value, in order.
+// The result has capacity equal to the length of the source and the
+// same Minsize and Shrink settings.  The source is not modified.
+// Conversions between numeric types may lose precision, as conv decides.
+func Convert[T, U any](d *Deque[T], conv func(T) U) *Deque[U] {
+	s := make([]U, d.len)
+	for i := range s {
+		s[i] = conv(d.dat[d.index(i)])
+	}
+	r := &Deque[U]{Minsize: d.Minsize, Shrink: d.Shrink}
+	r.wrap(s)
+	return r
+}
+
+// Parameters of the 64-bit FNV-1a hash.
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// Return an order-sensitive hash of the values, combining the result
+// of hashElem for each value from head to tail with FNV-1a.  Deques
+// holding the same values in the same order hash equally, regardless
+// of where the values sit in the backing store.  This is not a
+// multiset hash: reordering the values changes the result.
+func (d *Deque[T]) Hash(hashElem func(T) uint64) uint64 {
+	h := uint64(fnvOffset)
+	for i := 0; i < d.len; i++ {
+		e := hashElem(d.dat[d.index(i)])
+		for k := 0; k < 8; k++ {
+			h ^= e & 0xff
+			h *= fnvPrime
+			e >>= 8
+		}
+	}
+	return h
+}
+
+// Return a hash of the values that ignores their order, summing the
+// result of hashElem for each value after mixing its bits.  Deques
+// holding the same values with the same multiplicities hash equally.
+func (d *Deque[T]) HashUnordered(hashElem func(T) uint64) uint64 {
+	var h uint64
+	for i := 0; i < d.len; i++ {
+		e := hashElem(d.dat[d.index(i)])
+		// splitmix64 finalizer, so that sums don't cancel
+		e ^= e >> 30
+		e *= 0xbf58476d1ce4e5b9
+		e ^= e >> 27
+		e *= 0x94d049bb133111eb
+		e ^= e >> 31
+		h += e
+	}
+	return h
+}
+
+// Report whether two deques hold the same values with the same
+// multiplicities, in any order, comparing pairs with eq.  Since
+// only eq is available, this costs O(n^2) comparisons.
+func (d *Deque[T]) EqualUnordered(other *Deque[T], eq func(a, b T) bool) bool {
+	if d.len != other.len {
+		return false
+	}
+	used := make([]bool, other.len)
+outer:
+	for i := 0; i < d.len; i++ {
+		v := d.dat[d.index(i)]
+		for j := range used {
+			if !used[j] && eq(v, other.dat[other.index(j)]) {
+				used[j] = true
+				continue outer
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Number is the set of element types that can be summed.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Return a new slice whose k-th value is the sum of the first k+1
+// values of the deque, from the head.
+func PrefixSums[T Number](d *Deque[T]) []T {
+	s := make([]T, d.len)
+	var sum T
+	for i := range s {
+		sum += d.dat[d.index(i)]
+		s[i] = sum
+	}
+	return s
+}
+
+// Return a new slice whose k-th value is the sum of the values of the
+// deque from position k, counted from the head, through the tail.
+func SuffixSums[T Number](d *Deque[T]) []T {
+	s := make([]T, d.len)
+	var sum T
+	for i := d.len - 1; i >= 0; i-- {
+		sum += d.dat[d.index(i)]
+		s[i] = sum
+	}
+	return s
+}
+
+// Return the value at the p-th percentile, for p in [0, 1], by sorting
+// a copy of the values and taking the one nearest rank p*(Len()-1),
+// without interpolation.  When empty, return a zero value and false.
+// The deque is not modified.
+func Percentile[T cmp.Ordered](d *Deque[T], p float64) (v T, ok bool) {
+	if d.len == 0 {
+		return
+	}
+	s := d.ToSliceInto(nil)
+	slices.Sort(s)
+	p = min(max(p, 0), 1)
+	return s[int(math.Round(p*float64(d.len-1)))], true
+}
+
+// Return the value at the 50th percentile, as Percentile does.
+func Median[T cmp.Ordered](d *Deque[T]) (T, bool) {
+	return Percentile(d, 0.5)
+}
+
+// Fold the deque from both ends toward the middle, calling fn with
+// the accumulator and each pair of values at the same distance from
+// the head and the tail.  For an odd length, the last call passes the
+// middle value as both front and back.  The deque is not modified.
+func FoldEnds[T, A any](d *Deque[T], init A, fn func(acc A, front, back T) A) A {
+	acc := init
+	for i, j := 0, d.len-1; i <= j; i, j = i+1, j-1 {
+		acc = fn(acc, d.dat[d.index(i)], d.dat[d.index(j)])
+	}
+	return acc
+}
+
+// Fold the deque from the tail toward the head, calling fn with the
+// accumulator and each value.  The deque is not modified.
+func ReduceRight[T, A any](d *Deque[T], init A, fn func(A, T) A) A {
+	acc := init
+	for i := d.len - 1; i >= 0; i-- {
+		acc = fn(acc, d.dat[d.index(i)])
+	}
+	return acc
+}
+
+// OpKind identifies the edit an Op makes.
+type OpKind int
+
+// Kinds of edit an Op can make.
+const (
+	OpInsert OpKind = iota
+	OpDelete
+	OpReplace
+)
+
+// Op is an edit to a slice at position Index.  OpInsert places Value
+// before Index, OpDelete removes the value at Index, and OpReplace
+// overwrites the value at Index with Value.
+type Op[T any] struct {
+	Kind  OpKind
+	Index int
+	Value T
+}
+
+// Apply the ops in order from head to tail to a copy of base, with
+// each index referring to the result of the ops before it, and return
+// the result.  Neither the ops nor base are modified.  Panic if an
+// op's index is out of range, or its kind is unknown.
+func ApplyTo[T any](ops *Deque[Op[T]], base []T) []T {
+	s := slices.Clone(base)
+	for i := 0; i < ops.len; i++ {
+		op := ops.dat[ops.index(i)]
+		switch op.Kind {
+		case OpInsert:
+			s = slices.Insert(s, op.Index, op.Value)
+		case OpDelete:
+			s = slices.Delete(s, op.Index, op.Index+1)
+		case OpReplace:
+			s[op.Index] = op.Value
+		default:
+			panic("deque: ApplyTo unknown op kind")
+		}
+	}
+	return s
 }
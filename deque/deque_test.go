@@ -1,8 +1,12 @@
 package deque
 
 import (
+	"math/rand/v2"
 	"reflect"
+	"runtime"
+	"slices"
 	"testing"
+	"time"
 )
 
 func check(t *testing.T, op func() (int, bool), expected []int, empty bool) {
@@ -26,6 +30,41 @@ func check(t *testing.T, op func() (int, bool), expected []int, empty bool) {
 	}
 }
 
+// Return a full deque of 3, 4, 5, 6 whose values wrap around the end
+// of its backing store: the head is at index 2 of a capacity of 4.
+func wrapped(t *testing.T) Deque[int] {
+	t.Helper()
+	d := Deque[int]{Minsize: 4}
+	d.Push(1, 2, 3, 4)
+	check(t, d.Shift, []int{1, 2}, false)
+	d.Push(5, 6) // wraps
+	return d
+}
+
+// Return a deque of capacity 8 with its head at index head, holding
+// vs, which wrap around the end of the backing store when there are
+// more than 8-head of them.
+func wrappedAt(t *testing.T, head int, vs ...int) Deque[int] {
+	t.Helper()
+	d := Deque[int]{Minsize: 8}
+	d.Push(make([]int, head)...)
+	check(t, d.Shift, make([]int, head), false)
+	d.Push(vs...)
+	return d
+}
+
+// Report any slot of the backing store outside the values of d that
+// does not hold the zero value.
+func checkCleared[T comparable](t *testing.T, d *Deque[T]) {
+	t.Helper()
+	var zero T
+	for i := d.len; i < cap(d.dat); i++ {
+		if v := d.dat[d.index(i)]; v != zero {
+			t.Errorf("free slot %d holds %v, expected it cleared", d.index(i), v)
+		}
+	}
+}
+
 func TestPushPop(t *testing.T) {
 	d := Deque[int]{}
 	d.Push(1, 2, 3)
@@ -157,6 +196,29 @@ func TestSlices(t *testing.T) {
 	}
 }
 
+func TestToSliceInto(t *testing.T) {
+	d := Deque[int]{}
+	d.WrapSlice([]int{5, 6, 7, 8})
+	check(t, d.Shift, []int{5, 6}, false)
+	d.Push(9)
+	buf := make([]int, 1, 8)
+	s := d.ToSliceInto(buf)
+	es := []int{7, 8, 9}
+	if !reflect.DeepEqual(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+	if &s[0] != &buf[0] {
+		t.Error("expected dst to be reused")
+	}
+	if d.head != 2 {
+		t.Errorf("head %d, expected deque layout unchanged", d.head)
+	}
+	s = d.ToSliceInto(nil)
+	if !reflect.DeepEqual(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+}
+
 func TestPushPopString(t *testing.T) {
 	d := Deque[string]{}
 	d.Push("foo", "bar", "baz")
@@ -177,3 +239,2208 @@ func TestPushPopString(t *testing.T) {
 		t.Errorf("got %v, expected empty string", n)
 	}
 }
+
+func TestRemoveAll(t *testing.T) {
+	// exercise wraparound case, where head is after tail in slice
+	d := wrapped(t)
+	if n := d.RemoveAll(func(v int) bool { return v%2 == 0 }); n != 2 {
+		t.Errorf("removed %d, expected %d", n, 2)
+	}
+	checkCleared(t, &d)
+	check(t, d.Shift, []int{3, 5}, true)
+
+	if n := d.RemoveAll(func(int) bool { return true }); n != 0 {
+		t.Errorf("removed %d, expected %d", n, 0)
+	}
+}
+
+func TestMergeSorted(t *testing.T) {
+	less := func(x, y int) bool { return x < y }
+	a := wrapped(t)
+	b := Deque[int]{}
+	b.Push(2, 4, 5, 10)
+
+	d := MergeSorted(&a, &b, less)
+	if c := d.Cap(); c != 8 {
+		t.Errorf("capacity %d, expected %d", c, 8)
+	}
+	check(t, d.Shift, []int{2, 3, 4, 4, 5, 5, 6, 10}, true)
+	if a.Len() != 4 || b.Len() != 4 {
+		t.Error("expected inputs unchanged")
+	}
+
+	d = MergeSorted(&Deque[int]{}, &Deque[int]{}, less)
+	check(t, d.Shift, nil, true)
+	d.Push(1)
+	check(t, d.Shift, []int{1}, true)
+}
+
+func TestFree(t *testing.T) {
+	checkfree := func(dd Deque[int], eh, et int) {
+		if h, tl := dd.FreeHead(), dd.FreeTail(); h != eh || tl != et {
+			t.Errorf("free %d/%d, expected %d/%d", h, tl, eh, et)
+		}
+	}
+
+	d := Deque[int]{}
+	checkfree(d, 0, 0)
+	d = Deque[int]{Minsize: 8}
+	d.Push(1, 2, 3, 4)
+	checkfree(d, 0, 4)
+	check(t, d.Shift, []int{1}, false)
+	checkfree(d, 1, 4)
+	d.Unshift(0, -1)
+	checkfree(d, 3, 3)
+	d.Push(5, 6, 7)
+	checkfree(d, 0, 0)
+}
+
+func TestEqualSlice(t *testing.T) {
+	d := Deque[int]{}
+	if !EqualSlice(&d, nil) {
+		t.Error("expected empty deque to equal empty slice")
+	}
+	d = wrapped(t)
+	if !EqualSlice(&d, []int{3, 4, 5, 6}) {
+		t.Error("got false, expected true")
+	}
+	if EqualSlice(&d, []int{3, 4, 5}) || EqualSlice(&d, []int{3, 4, 5, 7}) {
+		t.Error("got true, expected false")
+	}
+	abs := func(a, b int) bool { return a == b || a == -b }
+	if !d.EqualSliceFunc([]int{-3, 4, -5, 6}, abs) {
+		t.Error("got false, expected true")
+	}
+	if d.head != 2 {
+		t.Errorf("head %d, expected deque layout unchanged", d.head)
+	}
+}
+
+func TestWatermark(t *testing.T) {
+	var events []int
+	d := Deque[int]{
+		HighWatermark:   4,
+		LowWatermark:    2,
+		OnHighWatermark: func(n int) { events = append(events, n) },
+		OnLowWatermark:  func(n int) { events = append(events, -n) },
+	}
+	d.Push(1, 2, 3)
+	d.Unshift(0)
+	d.Push(4)
+	check(t, d.Pop, []int{4, 3}, false)
+	d.Push(3, 4)
+	check(t, d.Shift, []int{0, 1, 2, 3}, false)
+	d.Unshift(3, 2, 1, 0)
+	es := []int{4, -1, 5}
+	if !reflect.DeepEqual(events, es) {
+		t.Errorf("got %v, expected %v", events, es)
+	}
+}
+
+func BenchmarkPushShift(b *testing.B) {
+	var d Deque[int]
+	for i := 0; i < b.N; i++ {
+		d.Push(i)
+		d.Push(i)
+		d.Shift()
+		d.Shift()
+	}
+}
+
+func TestPeekN(t *testing.T) {
+	d := wrapped(t)
+	d.Pop()
+
+	dst := make([]int, 4)
+	if n := d.PeekN(4, dst); n != 3 {
+		t.Errorf("copied %d, expected %d", n, 3)
+	}
+	es := []int{3, 4, 5, 0}
+	if !reflect.DeepEqual(dst, es) {
+		t.Errorf("got %v, expected %v", dst, es)
+	}
+	if n := d.PeekN(3, dst[:2]); n != 2 {
+		t.Errorf("copied %d, expected %d", n, 2)
+	}
+	if n := d.PeekN(-1, dst); n != 0 {
+		t.Errorf("copied %d, expected %d", n, 0)
+	}
+	check(t, d.Shift, []int{3, 4, 5}, true)
+}
+
+func TestDiscard(t *testing.T) {
+	d := wrapped(t)
+	if n := d.Discard(3); n != 3 {
+		t.Errorf("removed %d, expected %d", n, 3)
+	}
+	checkCleared(t, &d)
+	check(t, d.PeekShift, []int{6}, false)
+	if n := d.Discard(-1); n != 0 {
+		t.Errorf("removed %d, expected %d", n, 0)
+	}
+	if n := d.Discard(5); n != 1 {
+		t.Errorf("removed %d, expected %d", n, 1)
+	}
+	check(t, d.Shift, nil, true)
+
+	d.Push(1, 2, 3)
+	d.Unshift(0, -1) // wraps
+	if n := d.DiscardBack(3); n != 3 {
+		t.Errorf("removed %d, expected %d", n, 3)
+	}
+	check(t, d.Pop, []int{0, -1}, true)
+	if n := d.DiscardBack(1); n != 0 {
+		t.Errorf("removed %d, expected %d", n, 0)
+	}
+
+	d = Deque[int]{Minsize: 2, Shrink: ShrinkIfEmpty}
+	d.Push(1, 2, 3, 4, 5)
+	d.DiscardBack(5)
+	if c := d.Cap(); c != 2 {
+		t.Errorf("capacity %d, expected %d", c, 2)
+	}
+}
+
+func TestShrinkReleases(t *testing.T) {
+	// popping keeps head at 0, and shrinking must still let go of
+	// the large backing array
+	d := Deque[int]{Minsize: 2, Shrink: ShrinkAt20Pct}
+	d.Push(1, 2, 3, 4, 5)
+	backing := d.dat
+	check(t, d.Pop, []int{5, 4, 3, 2}, false)
+	if c := d.Cap(); c != 2 {
+		t.Errorf("capacity %d, expected %d", c, 2)
+	}
+	if &d.dat[0] == &backing[0] {
+		t.Error("expected a new backing array")
+	}
+	d.Push(2, 3)
+	check(t, d.Shift, []int{1, 2, 3}, true)
+}
+
+func TestPartition(t *testing.T) {
+	d := wrapped(t)
+	odd, even := Partition(&d, func(v int) bool { return v%2 == 1 })
+	odd.Push(7)
+	check(t, odd.Shift, []int{3, 5, 7}, true)
+	check(t, even.Shift, []int{4, 6}, true)
+	check(t, d.Shift, []int{3, 4, 5, 6}, true)
+}
+
+func TestWrapRing(t *testing.T) {
+	d := Deque[int]{}
+	d.WrapRing([]int{3, 4, 0, 1, 2}, 3, 4)
+	if c := d.Cap(); c != 5 {
+		t.Errorf("capacity %d, expected %d", c, 5)
+	}
+	d.Push(5)
+	check(t, d.Shift, []int{1, 2, 3, 4, 5}, true)
+
+	d.WrapRing(nil, 0, 0)
+	d.Push(1)
+	check(t, d.Pop, []int{1}, true)
+
+	for _, tc := range [][2]int{{-1, 0}, {4, 0}, {0, 5}, {0, -1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("head %d, length %d: expected panic", tc[0], tc[1])
+				}
+			}()
+			d.WrapRing(make([]int, 4), tc[0], tc[1])
+		}()
+	}
+}
+
+func TestPushChecked(t *testing.T) {
+	d := Deque[int]{Minsize: 2}
+	if d.PushChecked() {
+		t.Error("got true, expected false")
+	}
+	if !d.PushChecked(1) {
+		t.Error("got false, expected true")
+	}
+	if d.PushChecked(2) {
+		t.Error("got true, expected false")
+	}
+	if !d.PushChecked(3) {
+		t.Error("got false, expected true")
+	}
+	check(t, d.Shift, []int{1, 2, 3}, true)
+}
+
+func TestConvert(t *testing.T) {
+	d := Deque[int]{Minsize: 4, Shrink: ShrinkIfEmpty}
+	d.Push(1, 2, 3, 4)
+	check(t, d.Shift, []int{1, 2}, false)
+	d.Push(1<<32+5, 6) // wraps
+	r := Convert(&d, func(v int) int32 { return int32(v) })
+	if c := r.Cap(); c != 4 {
+		t.Errorf("capacity %d, expected %d", c, 4)
+	}
+	if r.Minsize != 4 || r.Shrink != ShrinkIfEmpty {
+		t.Errorf("settings %d/%d, expected %d/%d", r.Minsize, r.Shrink, 4, ShrinkIfEmpty)
+	}
+	if !EqualSlice(r, []int32{3, 4, 5, 6}) {
+		t.Errorf("got %v, expected %v", r.ToSlice(), []int32{3, 4, 5, 6})
+	}
+	check(t, d.Shift, []int{3, 4, 1<<32 + 5, 6}, true)
+}
+
+func TestSample(t *testing.T) {
+	d := wrappedAt(t, 3, 1, 2, 3, 4, 5, 6, 7)
+	for _, tc := range []struct {
+		step int
+		es   []int
+	}{
+		{0, []int{1, 2, 3, 4, 5, 6, 7}},
+		{2, []int{1, 3, 5, 7}},
+		{3, []int{1, 4, 7}},
+		{10, []int{1}},
+	} {
+		if s := d.Sample(tc.step); !reflect.DeepEqual(s, tc.es) {
+			t.Errorf("step %d: got %v, expected %v", tc.step, s, tc.es)
+		}
+	}
+	if s := (&Deque[int]{}).Sample(2); len(s) != 0 {
+		t.Errorf("got %v, expected empty slice", s)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	d := Deque[int]{Minsize: 8}
+	d.Push(1, 2, 3, 4, 5)
+	check(t, d.Shift, []int{1, 2, 3}, false)
+	d.Push(6, 7, 8, 9) // wraps
+	backing := d.dat
+	d.Rebalance()
+	if h, tl := d.FreeHead(), d.FreeTail(); h != 1 || tl != 1 {
+		t.Errorf("free %d/%d, expected %d/%d", h, tl, 1, 1)
+	}
+	if &d.dat[0] != &backing[0] {
+		t.Error("expected the same backing array")
+	}
+	es := []int{0, 4, 5, 6, 7, 8, 9, 0}
+	if !reflect.DeepEqual(d.dat, es) {
+		t.Errorf("got %v, expected %v", d.dat, es)
+	}
+	d.Unshift(3)
+	d.Push(10)
+	if c := d.Cap(); c != 8 {
+		t.Errorf("capacity %d, expected %d", c, 8)
+	}
+	check(t, d.Shift, []int{3, 4, 5, 6, 7, 8, 9, 10}, true)
+
+	d.Rebalance()
+	if d.head != 4 {
+		t.Errorf("head %d, expected %d", d.head, 4)
+	}
+	d.Push(1)
+	check(t, d.Pop, []int{1}, true)
+
+	// an adopted slice stays adopted
+	s := []int{1, 2, 3, 0, 0}
+	d.WrapSlice(s[:3])
+	d.Rebalance()
+	if !d.OwnsBackingArray() {
+		t.Error("got false, expected true")
+	}
+	es = []int{0, 1, 2, 3, 0}
+	if !reflect.DeepEqual(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	d := Deque[int]{Minsize: 4, ZeroValue: -1, ZeroSet: true}
+	d.Push(1, 2, 3, 4)
+	check(t, d.Shift, []int{1}, false)
+	check(t, d.Pop, []int{4}, false)
+	d.RemoveAll(func(v int) bool { return v == 2 })
+	es := []int{-1, 3, -1, -1}
+	if !reflect.DeepEqual(d.dat, es) {
+		t.Errorf("got %v, expected %v", d.dat, es)
+	}
+	d.Discard(1)
+	es = []int{-1, -1, -1, -1}
+	if !reflect.DeepEqual(d.dat, es) {
+		t.Errorf("got %v, expected %v", d.dat, es)
+	}
+}
+
+func TestHash(t *testing.T) {
+	id := func(v int) uint64 { return uint64(v) }
+	a := wrapped(t)
+	b := Deque[int]{}
+	b.Push(3, 4, 5, 6)
+	if a.Hash(id) != b.Hash(id) {
+		t.Error("expected equal hashes for equal contents")
+	}
+	b.Pop()
+	if a.Hash(id) == b.Hash(id) {
+		t.Error("expected different hashes for different lengths")
+	}
+	c := Deque[int]{}
+	c.Push(3, 4, 6, 5)
+	if a.Hash(id) == c.Hash(id) {
+		t.Error("expected different hashes for different orders")
+	}
+}
+
+func TestUnordered(t *testing.T) {
+	id := func(v int) uint64 { return uint64(v) }
+	eq := func(a, b int) bool { return a == b }
+	a := wrapped(t)
+	a.Pop()
+	a.Push(3)
+	b := Deque[int]{}
+	b.Push(5, 3, 4, 3)
+	if a.HashUnordered(id) != b.HashUnordered(id) {
+		t.Error("expected equal hashes for permuted contents")
+	}
+	if !a.EqualUnordered(&b, eq) {
+		t.Error("got false, expected true")
+	}
+	c := Deque[int]{}
+	c.Push(5, 4, 4, 3)
+	if a.HashUnordered(id) == c.HashUnordered(id) {
+		t.Error("expected different hashes for different multiplicities")
+	}
+	if a.EqualUnordered(&c, eq) {
+		t.Error("got true, expected false")
+	}
+	c.Pop()
+	if a.EqualUnordered(&c, eq) {
+		t.Error("got true, expected false")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	d := Deque[int]{}
+	d.WrapRing([]int{3, 4, 0, 1, 2}, 3, 4)
+	moved := make([]int, 5)
+	copy(moved, d.dat)
+	d.Rebind(moved[:0])
+	if &d.dat[0] != &moved[0] {
+		t.Error("expected new backing array")
+	}
+	check(t, d.Shift, []int{1, 2, 3, 4}, true)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	d.Rebind(make([]int, 4))
+}
+
+func TestInternals(t *testing.T) {
+	d := wrapped(t)
+	d.Shift()
+	d.Pop()
+	if h, tl := d.Head(), d.Tail(); h != 3 || tl != 0 {
+		t.Errorf("head/tail %d/%d, expected %d/%d", h, tl, 3, 0)
+	}
+	es := []int{5, 0, 0, 4}
+	if b := d.Backing(); !reflect.DeepEqual(b, es) {
+		t.Errorf("got %v, expected %v", b, es)
+	}
+}
+
+func TestRetain(t *testing.T) {
+	d := wrapped(t)
+	d.Retain([]int{3, 1, 3, -1, 4})
+	es := []int{0, 0, 4, 6}
+	if !reflect.DeepEqual(d.dat, es) {
+		t.Errorf("got %v, expected %v", d.dat, es)
+	}
+	check(t, d.Shift, []int{4, 6}, true)
+}
+
+func TestChunks(t *testing.T) {
+	d := wrappedAt(t, 3, 1, 2, 3, 4, 5, 6, 7)
+	var got [][]int
+	for c := range d.Chunks(3) {
+		got = append(got, c)
+	}
+	es := [][]int{{1, 2, 3}, {4, 5, 6}, {7}}
+	if !reflect.DeepEqual(got, es) {
+		t.Errorf("got %v, expected %v", got, es)
+	}
+	for c := range d.Chunks(0) {
+		if len(c) != 1 {
+			t.Errorf("chunk length %d, expected %d", len(c), 1)
+		}
+		break
+	}
+	if n := d.Len(); n != 7 {
+		t.Errorf("length %d, expected %d", n, 7)
+	}
+}
+
+func TestDrainChunks(t *testing.T) {
+	d := wrappedAt(t, 3, 1, 2, 3, 4, 5, 6, 7)
+	var got [][]int
+	for c := range d.DrainChunks(2) {
+		got = append(got, c)
+		if len(got) == 2 {
+			break
+		}
+	}
+	es := [][]int{{1, 2}, {3, 4}}
+	if !reflect.DeepEqual(got, es) {
+		t.Errorf("got %v, expected %v", got, es)
+	}
+	if n := d.Len(); n != 3 {
+		t.Errorf("length %d, expected %d", n, 3)
+	}
+	got = nil
+	for c := range d.DrainChunks(2) {
+		got = append(got, c)
+	}
+	es = [][]int{{5, 6}, {7}}
+	if !reflect.DeepEqual(got, es) {
+		t.Errorf("got %v, expected %v", got, es)
+	}
+	checkCleared(t, &d)
+	check(t, d.Shift, nil, true)
+}
+
+func TestTransferTailToHead(t *testing.T) {
+	d := wrapped(t)
+	dst := Deque[int]{Minsize: 2}
+	dst.Push(7, 8)
+	if n := d.TransferTailToHead(&dst, 3); n != 3 {
+		t.Errorf("moved %d, expected %d", n, 3)
+	}
+	if c := dst.Cap(); c != 8 {
+		t.Errorf("capacity %d, expected %d", c, 8)
+	}
+	check(t, d.Shift, []int{3}, true)
+	check(t, dst.Shift, []int{4, 5, 6, 7, 8}, true)
+
+	d.Push(1, 2, 3, 4)
+	if n := d.TransferTailToHead(&d, 5); n != 4 {
+		t.Errorf("moved %d, expected %d", n, 4)
+	}
+	check(t, d.Shift, []int{1, 2, 3, 4}, true)
+	d.Push(1, 2, 3, 4)
+	d.TransferTailToHead(&d, 1)
+	check(t, d.Shift, []int{4, 1, 2, 3}, true)
+}
+
+// Grow by a fixed step of 3, and shrink whenever a step is unused.
+type stepPolicy struct{}
+
+func (stepPolicy) Grow(len, cap, add int) int {
+	for cap < len+add {
+		cap += 3
+	}
+	return cap
+}
+
+func (stepPolicy) Shrink(len, cap, minsize int) int {
+	if cap-len >= 3 && cap > minsize {
+		return cap - 3
+	}
+	return cap
+}
+
+func TestPolicy(t *testing.T) {
+	checkcap := func(dd Deque[int], ec int) {
+		if c := dd.Cap(); c != ec {
+			t.Errorf("capacity %d, expected %d", c, ec)
+		}
+	}
+
+	d := Deque[int]{Minsize: 3, Policy: stepPolicy{}}
+	d.Push(1, 2, 3, 4)
+	checkcap(d, 6)
+	d.Push(5, 6, 7)
+	checkcap(d, 9)
+	check(t, d.Shift, []int{1, 2}, false)
+	checkcap(d, 6)
+	check(t, d.Shift, []int{3, 4, 5, 6, 7}, true)
+	checkcap(d, 3)
+
+	// the default policy matches the built-in behavior
+	d = Deque[int]{Minsize: 2, Policy: DefaultPolicy{Minsize: 2, Mode: ShrinkAt20Pct}}
+	d.Push(1, 2, 3, 4, 5)
+	checkcap(d, 8)
+	check(t, d.Shift, []int{1, 2, 3, 4}, false)
+	checkcap(d, 2)
+	check(t, d.Shift, []int{5}, true)
+
+	// the policy's Minsize decides, whatever the deque's
+	d = Deque[int]{Minsize: 64, Policy: DefaultPolicy{Minsize: 4, Mode: ShrinkAt20Pct}}
+	d.Push(make([]int, 128)...)
+	checkcap(d, 128)
+	d.Discard(127)
+	checkcap(d, 4)
+	d = Deque[int]{Policy: DefaultPolicy{Mode: ShrinkIfEmpty}}
+	e := Deque[int]{Shrink: ShrinkIfEmpty}
+	d.Push(make([]int, 100)...)
+	e.Push(make([]int, 100)...)
+	d.Discard(100)
+	e.Discard(100)
+	checkcap(d, DefaultSize)
+	checkcap(d, e.Cap())
+
+	// shrinking a wrapped-slice deque back up to Minsize
+	d = Deque[int]{Minsize: 8, Shrink: ShrinkIfEmpty}
+	d.WrapSlice([]int{1, 2})
+	check(t, d.Pop, []int{2, 1}, true)
+	checkcap(d, 8)
+
+	// a policy never shrinks a deque to a larger capacity
+	d = Deque[int]{Policy: DefaultPolicy{Minsize: 1024, Mode: ShrinkIfEmpty}}
+	d.WrapSlice([]int{1, 2, 3, 4})
+	check(t, d.Pop, []int{4, 3, 2, 1}, true)
+	checkcap(d, 4)
+}
+
+func TestRuns(t *testing.T) {
+	odd := func(v int) bool { return v%2 == 1 }
+	d := wrappedAt(t, 5, 1, 3, 4, 5, 7, 9, 2)
+	if n := d.RunLength(odd); n != 2 {
+		t.Errorf("run %d, expected %d", n, 2)
+	}
+	if n := d.MaxRun(odd); n != 3 {
+		t.Errorf("run %d, expected %d", n, 3)
+	}
+	d.Shift()
+	d.Shift()
+	if n := d.RunLength(odd); n != 0 {
+		t.Errorf("run %d, expected %d", n, 0)
+	}
+	if n := (&Deque[int]{}).MaxRun(odd); n != 0 {
+		t.Errorf("run %d, expected %d", n, 0)
+	}
+}
+
+func TestPushIfAbsent(t *testing.T) {
+	type task struct {
+		id   int
+		name string
+	}
+	id := func(v task) int { return v.id }
+	d := Deque[task]{}
+	if !PushIfAbsent(&d, task{1, "a"}, id) || !PushIfAbsent(&d, task{2, "b"}, id) {
+		t.Error("got false, expected true")
+	}
+	if PushIfAbsent(&d, task{1, "c"}, id) {
+		t.Error("got true, expected false")
+	}
+	es := []task{{1, "a"}, {2, "b"}}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+}
+
+func TestTakeSlice(t *testing.T) {
+	d := wrapped(t)
+	d.Shift()
+	backing := d.dat
+	s := d.TakeSlice()
+	es := []int{4, 5, 6}
+	if !reflect.DeepEqual(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+	if &s[0] != &backing[0] {
+		t.Error("expected backing array to be reused")
+	}
+	if d.Len() != 0 || d.Cap() != 0 {
+		t.Errorf("length/capacity %d/%d, expected empty", d.Len(), d.Cap())
+	}
+	d.Push(7)
+	check(t, d.Shift, []int{7}, true)
+	if d.Cap() != 4 {
+		t.Errorf("capacity %d, expected %d", d.Cap(), 4)
+	}
+	if s := (&Deque[int]{}).TakeSlice(); len(s) != 0 {
+		t.Errorf("got %v, expected empty slice", s)
+	}
+}
+
+func TestShrinkFloor(t *testing.T) {
+	checkcap := func(dd Deque[int], ec int) {
+		if c := dd.Cap(); c != ec {
+			t.Errorf("capacity %d, expected %d", c, ec)
+		}
+	}
+
+	d := Deque[int]{Minsize: 2, Shrink: ShrinkIfEmpty, ShrinkFloor: 4}
+	d.Push(1, 2, 3, 4, 5, 6, 7, 8, 9)
+	checkcap(d, 16)
+	check(t, d.Shift, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}, true)
+	checkcap(d, 4)
+	d.Push(1, 2)
+	check(t, d.Shift, []int{1, 2}, true)
+	checkcap(d, 4)
+
+	// a floor at or above the capacity prevents shrinking
+	d = Deque[int]{Minsize: 2, Shrink: ShrinkIfEmpty, ShrinkFloor: 16}
+	d.Push(1, 2, 3, 4, 5)
+	check(t, d.Shift, []int{1, 2, 3, 4, 5}, true)
+	checkcap(d, 8)
+}
+
+func TestEvictOlderThan(t *testing.T) {
+	ts := func(v int) int64 { return int64(v) }
+	d := wrapped(t)
+	if n := EvictOlderThan(&d, 5, ts); n != 2 {
+		t.Errorf("removed %d, expected %d", n, 2)
+	}
+	if n := EvictOlderThan(&d, 5, ts); n != 0 {
+		t.Errorf("removed %d, expected %d", n, 0)
+	}
+	check(t, d.PeekShift, []int{5}, false)
+	if n := EvictOlderThan(&d, 10, ts); n != 2 {
+		t.Errorf("removed %d, expected %d", n, 2)
+	}
+	check(t, d.Shift, nil, true)
+}
+
+func TestCompactDuplicates(t *testing.T) {
+	eq := func(a, b int) bool { return a == b }
+	d := wrappedAt(t, 5, 1, 1, 2, 2, 2, 3, 1, 1)
+	if n := d.CompactDuplicates(eq); n != 4 {
+		t.Errorf("removed %d, expected %d", n, 4)
+	}
+	check(t, d.Shift, []int{1, 2, 3, 1}, true)
+	if n := d.CompactDuplicates(eq); n != 0 {
+		t.Errorf("removed %d, expected %d", n, 0)
+	}
+}
+
+func TestPrependSlice(t *testing.T) {
+	d := Deque[int]{Minsize: 8}
+	d.Push(4, 5)
+	d.PrependSlice([]int{1, 2, 3}) // wraps
+	d.PrependSlice(nil)
+	if c := d.Cap(); c != 8 {
+		t.Errorf("capacity %d, expected %d", c, 8)
+	}
+	d.PrependSlice([]int{-2, -1, 0, 0, 0, 0})
+	if c := d.Cap(); c != 16 {
+		t.Errorf("capacity %d, expected %d", c, 16)
+	}
+	check(t, d.Shift, []int{-2, -1, 0, 0, 0, 0, 1, 2, 3, 4, 5}, true)
+
+	d = Deque[int]{}
+	d.PrependSlice([]int{1, 2})
+	check(t, d.Pop, []int{2, 1}, true)
+}
+
+func TestSetMinsize(t *testing.T) {
+	checkcap := func(dd Deque[int], ec int) {
+		if c := dd.Cap(); c != ec {
+			t.Errorf("capacity %d, expected %d", c, ec)
+		}
+	}
+
+	d := Deque[int]{Minsize: 16, Shrink: ShrinkAt20Pct}
+	d.Push(1, 2, 3)
+	d.SetMinsize(4)
+	checkcap(d, 4)
+	d.SetMinsize(2)
+	checkcap(d, 4)
+	d.SetMinsize(8)
+	checkcap(d, 4)
+	check(t, d.Shift, []int{1, 2, 3}, true)
+
+	d = Deque[int]{Minsize: 16}
+	d.Push(1)
+	d.SetMinsize(4)
+	checkcap(d, 16)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	d.SetMinsize(0)
+}
+
+func TestZeroOnGrow(t *testing.T) {
+	d := Deque[int]{Minsize: 2, Shrink: ShrinkIfEmpty, ZeroOnGrow: true}
+	d.Push(1, 2)
+	old := d.dat
+	d.Push(3)
+	es := []int{0, 0}
+	if !reflect.DeepEqual(old, es) {
+		t.Errorf("got %v, expected %v", old, es)
+	}
+	d.Shift()
+	check(t, d.Shift, []int{2, 3}, true)
+
+	// a slice from the caller is left as it is
+	s := []int{1, 2}
+	d.WrapSlice(s)
+	d.Push(3)
+	if es := []int{1, 2}; !reflect.DeepEqual(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+}
+
+func TestRangeIndices(t *testing.T) {
+	d := wrapped(t)
+	var got [][2]int
+	for i, v := range d.RangeIndices(-1, 3) {
+		got = append(got, [2]int{i, v})
+	}
+	es := [][2]int{{0, 3}, {1, 4}, {2, 5}}
+	if !reflect.DeepEqual(got, es) {
+		t.Errorf("got %v, expected %v", got, es)
+	}
+	got = nil
+	for i, v := range d.RangeIndices(2, 10) {
+		got = append(got, [2]int{i, v})
+		break
+	}
+	es = [][2]int{{2, 5}}
+	if !reflect.DeepEqual(got, es) {
+		t.Errorf("got %v, expected %v", got, es)
+	}
+	for range d.RangeIndices(3, 3) {
+		t.Error("expected no values")
+	}
+}
+
+func TestGrowBase(t *testing.T) {
+	checkcap := func(dd Deque[int], ec int) {
+		if c := dd.Cap(); c != ec {
+			t.Errorf("capacity %d, expected %d", c, ec)
+		}
+	}
+
+	d := Deque[int]{Minsize: 3, Shrink: ShrinkIfEmpty, GrowBase: 4}
+	d.Push(1)
+	checkcap(d, 4)
+	d.Push(2, 3, 4, 5)
+	checkcap(d, 8)
+	check(t, d.Shift, []int{1, 2, 3, 4, 5}, true)
+	checkcap(d, 3)
+	d.Push(1, 2, 3, 4)
+	checkcap(d, 4)
+	d.Push(5, 6, 7, 8, 9)
+	checkcap(d, 16)
+	check(t, d.Shift, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}, true)
+}
+
+func TestSorted(t *testing.T) {
+	less := func(a, b int) bool { return a < b }
+	d := Deque[int]{}
+	if !d.IsMonotonic(less) || !d.IsSorted(less) {
+		t.Error("got false, expected true")
+	}
+	d = wrapped(t)
+	if !d.IsMonotonic(less) || !d.IsSorted(less) {
+		t.Error("got false, expected true")
+	}
+	d.Pop()
+	d.Push(5)
+	if d.IsMonotonic(less) {
+		t.Error("got true, expected false")
+	}
+	if !d.IsSorted(less) {
+		t.Error("got false, expected true")
+	}
+	d.Pop()
+	d.Push(4)
+	if d.IsMonotonic(less) || d.IsSorted(less) {
+		t.Error("got true, expected false")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	a := wrapped(t)
+	a.Pop()
+	b := Deque[int]{Minsize: 2}
+	b.Push(0, 4)
+	b.Shift()
+	b.Push(5) // wraps
+	d := Join(&a, &Deque[int]{}, &b, &a)
+	if c := d.Cap(); c != 8 {
+		t.Errorf("capacity %d, expected %d", c, 8)
+	}
+	check(t, d.Shift, []int{3, 4, 5, 4, 5, 3, 4, 5}, true)
+	check(t, b.Shift, []int{4, 5}, true)
+
+	d = Join[int]()
+	d.Push(1)
+	check(t, d.Shift, []int{1}, true)
+}
+
+func TestSuspendShrink(t *testing.T) {
+	checkcap := func(dd Deque[int], ec int) {
+		if c := dd.Cap(); c != ec {
+			t.Errorf("capacity %d, expected %d", c, ec)
+		}
+	}
+
+	d := Deque[int]{Minsize: 2, Shrink: ShrinkAt20Pct}
+	d.Push(1, 2, 3, 4, 5)
+	d.SuspendShrink()
+	d.SuspendShrink()
+	check(t, d.Shift, []int{1, 2, 3, 4}, false)
+	checkcap(d, 8)
+	d.ResumeShrink()
+	checkcap(d, 8)
+	d.ResumeShrink()
+	checkcap(d, 2)
+	d.ResumeShrink()
+	check(t, d.Shift, []int{5}, true)
+
+	d.Push(1, 2, 3, 4, 5)
+	for range d.DrainChunks(2) {
+		checkcap(d, 8)
+	}
+	checkcap(d, 2)
+}
+
+func TestRotateToIndex(t *testing.T) {
+	for i, es := range [][]int{
+		{3, 4, 5, 6, 7},
+		{4, 5, 6, 7, 3},
+		{5, 6, 7, 3, 4},
+		{6, 7, 3, 4, 5},
+		{7, 3, 4, 5, 6},
+		{3, 4, 5, 6, 7},
+	} {
+		d := wrappedAt(t, 3, 3, 4, 5, 6, 7)
+		d.RotateToIndex(i)
+		if !EqualSlice(&d, es) {
+			t.Errorf("index %d: got %v, expected %v", i, d.ToSlice(), es)
+		}
+
+		// full deques just move the head
+		d.Push(8, 9, 10)
+		d.RotateToIndex(i)
+		if d.Len() != 8 || d.Cap() != 8 {
+			t.Errorf("length/capacity %d/%d, expected %d/%d", d.Len(), d.Cap(), 8, 8)
+		}
+	}
+
+	d := wrapped(t)
+	d.RotateToIndex(3)
+	check(t, d.Shift, []int{6, 3, 4, 5}, true)
+}
+
+func TestSums(t *testing.T) {
+	d := Deque[float64]{Minsize: 4}
+	d.Push(0, 0, 1, 2)
+	d.Shift()
+	d.Shift()
+	d.Push(3, 4.5) // wraps
+	s := PrefixSums(&d)
+	es := []float64{1, 3, 6, 10.5}
+	if !reflect.DeepEqual(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+	s = SuffixSums(&d)
+	es = []float64{10.5, 9.5, 7.5, 4.5}
+	if !reflect.DeepEqual(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+	if s := PrefixSums(&Deque[uint8]{}); len(s) != 0 {
+		t.Errorf("got %v, expected empty slice", s)
+	}
+}
+
+func TestToSlicePreferNoCopy(t *testing.T) {
+	d := Deque[int]{Minsize: 4}
+	d.Push(1, 2, 3)
+	s, ok := d.ToSlicePreferNoCopy()
+	if !ok || !reflect.DeepEqual(s, []int{1, 2, 3}) {
+		t.Errorf("got %v/%v, expected %v/true", s, ok, []int{1, 2, 3})
+	}
+	s[0] = 0
+	if !EqualSlice(&d, []int{0, 2, 3}) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), []int{0, 2, 3})
+	}
+	check(t, d.Shift, []int{0, 2}, false)
+	d.Push(4, 5) // wraps
+	s, ok = d.ToSlicePreferNoCopy()
+	if ok || !reflect.DeepEqual(s, []int{3, 4, 5}) {
+		t.Errorf("got %v/%v, expected %v/false", s, ok, []int{3, 4, 5})
+	}
+	if d.head != 2 {
+		t.Errorf("head %d, expected deque layout unchanged", d.head)
+	}
+	s, ok = (&Deque[int]{}).ToSlicePreferNoCopy()
+	if ok || s == nil || len(s) != 0 {
+		t.Errorf("got %v/%v, expected empty slice/false", s, ok)
+	}
+}
+
+func benchmarkPrepend(b *testing.B, prepend func(*Deque[int], []int)) {
+	s := make([]int, 4096)
+	d := Deque[int]{Minsize: 2 * len(s)}
+	for i := 0; i < b.N; i++ {
+		d.Push(0)
+		prepend(&d, s)
+		d.Discard(d.Len())
+	}
+}
+
+func BenchmarkPrependSlice(b *testing.B) {
+	benchmarkPrepend(b, func(d *Deque[int], s []int) { d.PrependSlice(s) })
+}
+
+func BenchmarkPrependUnshift(b *testing.B) {
+	benchmarkPrepend(b, func(d *Deque[int], s []int) {
+		slices.Reverse(s)
+		d.Unshift(s...)
+	})
+}
+
+// Prepend many batches to a new deque, so it resizes along the way.
+func benchmarkPrependGrow(b *testing.B, prepend func(*Deque[int], []int)) {
+	s := make([]int, 256)
+	for i := 0; i < b.N; i++ {
+		var d Deque[int]
+		for j := 0; j < 64; j++ {
+			prepend(&d, s)
+		}
+	}
+}
+
+func BenchmarkPrependSliceGrow(b *testing.B) {
+	benchmarkPrependGrow(b, func(d *Deque[int], s []int) { d.PrependSlice(s) })
+}
+
+func BenchmarkPrependUnshiftGrow(b *testing.B) {
+	benchmarkPrependGrow(b, func(d *Deque[int], s []int) {
+		slices.Reverse(s)
+		d.Unshift(s...)
+	})
+}
+
+func TestPresent(t *testing.T) {
+	one, two := 1, 2
+	present := func(p *int) bool { return p != nil }
+	d := Deque[*int]{Minsize: 4}
+	d.Push(nil, nil, &one, nil)
+	d.Shift()
+	d.Shift()
+	d.Push(&two, nil) // wraps
+	s := d.PresentIndices(present)
+	es := []int{0, 2}
+	if !reflect.DeepEqual(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+	if n := d.CountPresent(present); n != 2 {
+		t.Errorf("count %d, expected %d", n, 2)
+	}
+}
+
+func TestMaxResize(t *testing.T) {
+	checkcap := func(dd Deque[int], ec int) {
+		if c := dd.Cap(); c != ec {
+			t.Errorf("capacity %d, expected %d", c, ec)
+		}
+	}
+
+	d := Deque[int]{Minsize: 4, MaxResize: 10}
+	d.Push(1, 2, 3, 4, 5)
+	checkcap(d, 8)
+	d.Push(6, 7, 8, 9)
+	checkcap(d, 10)
+	d.Push(make([]int, 15)...)
+	checkcap(d, 24)
+	d.Push(0)
+	checkcap(d, 34)
+	check(t, d.Shift, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}, false)
+	if n := d.Len(); n != 16 {
+		t.Errorf("length %d, expected %d", n, 16)
+	}
+
+	// past the limit, single pushes resize once every MaxResize values
+	d = Deque[int]{Minsize: 4, MaxResize: 10}
+	d.Push(make([]int, 10)...)
+	resizes := 0
+	for i := 0; i < 100; i++ {
+		c := d.Cap()
+		d.Push(i)
+		if d.Cap() != c {
+			resizes++
+		}
+	}
+	if resizes != 10 {
+		t.Errorf("resized %d times, expected %d", resizes, 10)
+	}
+	checkcap(d, 110)
+}
+
+func TestUpdate(t *testing.T) {
+	d := wrapped(t)
+	d.Update(func(i, v int) int { return v*10 + i })
+	check(t, d.Shift, []int{30, 41, 52, 63}, true)
+}
+
+func TestAllSnapshot(t *testing.T) {
+	d := wrapped(t)
+	d.Pop()
+	var got []int
+	for v := range d.AllSnapshot() {
+		got = append(got, v)
+		d.Push(v * 10) // grows on the second push
+	}
+	es := []int{3, 4, 5}
+	if !reflect.DeepEqual(got, es) {
+		t.Errorf("got %v, expected %v", got, es)
+	}
+	check(t, d.Shift, []int{3, 4, 5, 30, 40, 50}, true)
+}
+
+func TestPercentile(t *testing.T) {
+	d := wrappedAt(t, 4, 9, 3, 7, 1, 5, 2, 8)
+	for _, tc := range []struct {
+		p  float64
+		ev int
+	}{
+		{-1, 1}, {0, 1}, {0.25, 3}, {0.5, 5}, {0.9, 8}, {1, 9}, {2, 9},
+	} {
+		if v, ok := Percentile(&d, tc.p); !ok || v != tc.ev {
+			t.Errorf("p %v: got %v/%v, expected %v/true", tc.p, v, ok, tc.ev)
+		}
+	}
+	if v, ok := Median(&d); !ok || v != 5 {
+		t.Errorf("got %v/%v, expected %v/true", v, ok, 5)
+	}
+	check(t, d.PeekShift, []int{9}, false)
+	if v, ok := Median(&Deque[string]{}); ok || v != "" {
+		t.Errorf("got %q/%v, expected empty string/false", v, ok)
+	}
+}
+
+func TestPinCapacity(t *testing.T) {
+	checkcap := func(dd Deque[int], ec int) {
+		if c := dd.Cap(); c != ec {
+			t.Errorf("capacity %d, expected %d", c, ec)
+		}
+	}
+
+	d := Deque[int]{Minsize: 2, Shrink: ShrinkIfEmpty}
+	d.Push(1, 2, 3, 4, 5)
+	d.PinCapacity()
+	d.PinCapacity()
+	d.SuspendShrink()
+	check(t, d.Shift, []int{1, 2, 3, 4, 5}, true)
+	d.UnpinCapacity()
+	d.UnpinCapacity()
+	checkcap(d, 8)
+	d.PinCapacity()
+	d.ResumeShrink()
+	checkcap(d, 8)
+	d.UnpinCapacity()
+	checkcap(d, 2)
+	if d.Shrink != ShrinkIfEmpty {
+		t.Errorf("shrink %d, expected %d", d.Shrink, ShrinkIfEmpty)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	d := Deque[int]{}
+	d.WrapRing([]int{3, 4, 0, 1, 2}, 3, 4)
+	if d.Normalize() {
+		t.Error("got true, expected false")
+	}
+	d.len = 7
+	if !d.Normalize() {
+		t.Error("got false, expected true")
+	}
+	check(t, d.PeekShift, []int{1}, false)
+	check(t, d.Peek, []int{0}, false)
+	d.head, d.tail = 9, 9
+	if !d.Normalize() {
+		t.Error("got false, expected true")
+	}
+	check(t, d.Shift, []int{3, 4, 0, 1, 2}, true)
+
+	d = Deque[int]{}
+	if d.Normalize() {
+		t.Error("got true, expected false")
+	}
+}
+
+func TestShiftUntilSize(t *testing.T) {
+	size := func(b []byte) int { return len(b) }
+	d := Deque[[]byte]{Minsize: 4}
+	d.Push(nil, nil, []byte("ab"), []byte("cde"))
+	d.Shift()
+	d.Shift()
+	d.Push([]byte("fghijk"), []byte("l")) // wraps
+	for _, tc := range []struct {
+		max int
+		es  [][]byte
+	}{
+		{5, [][]byte{[]byte("ab"), []byte("cde")}},
+		{3, [][]byte{[]byte("fghijk")}},
+		{3, [][]byte{[]byte("l")}},
+		{3, [][]byte{}},
+	} {
+		if s := d.ShiftUntilSize(tc.max, size); !reflect.DeepEqual(s, tc.es) {
+			t.Errorf("got %q, expected %q", s, tc.es)
+		}
+	}
+	for i, v := range d.dat {
+		if v != nil {
+			t.Errorf("slot %d holds %q, expected it cleared", i, v)
+		}
+	}
+}
+
+func TestZip(t *testing.T) {
+	a := wrapped(t)
+	b := Deque[string]{}
+	b.Push("a", "b", "c")
+	z := Zip(&a, &b)
+	es := []Pair[int, string]{{3, "a"}, {4, "b"}, {5, "c"}}
+	if !EqualSlice(z, es) {
+		t.Errorf("got %v, expected %v", z.ToSlice(), es)
+	}
+	za, zb := Unzip(z)
+	check(t, za.Shift, []int{3, 4, 5}, true)
+	if !EqualSlice(zb, []string{"a", "b", "c"}) {
+		t.Errorf("got %v, expected %v", zb.ToSlice(), []string{"a", "b", "c"})
+	}
+	check(t, a.Shift, []int{3, 4, 5, 6}, true)
+}
+
+func TestSeq(t *testing.T) {
+	checkseq := func(dd *Deque[int], es []uint64) {
+		var s []uint64
+		for i := 0; ; i++ {
+			n, ok := dd.SeqAt(i)
+			if !ok {
+				break
+			}
+			s = append(s, n)
+		}
+		if !reflect.DeepEqual(s, es) {
+			t.Errorf("got %v, expected %v", s, es)
+		}
+	}
+
+	d := Deque[int]{Minsize: 4}
+	if _, ok := d.SeqAt(0); ok {
+		t.Error("got true, expected false")
+	}
+	d.Push(10, 11)
+	d.TrackSeq()
+	d.Push(12, 13)
+	d.Unshift(9) // grows
+	d.Shift()    // seq 4
+	d.Push(14)   // seq 5
+	d.RotateToIndex(2)
+	checkseq(&d, []uint64{2, 3, 5, 0, 1})
+	d.RemoveAll(func(v int) bool { return v == 14 })
+	checkseq(&d, []uint64{2, 3, 0, 1})
+	d.PrependSlice([]int{1, 2, 3, 4, 5})
+	checkseq(&d, []uint64{6, 7, 8, 9, 10, 2, 3, 0, 1})
+	d.Rebalance()
+	d.Discard(5)
+	d.TransferTailToHead(&d, 1)
+	d.Unshift(0)
+	checkseq(&d, []uint64{11, 1, 2, 3, 0})
+	d.ToSlice()
+	checkseq(&d, []uint64{11, 1, 2, 3, 0})
+	check(t, d.Shift, []int{0, 11, 12, 13, 10}, true)
+	d.TakeSlice()
+	d.Push(1)
+	checkseq(&d, []uint64{12})
+}
+
+func TestReplaceAll(t *testing.T) {
+	d := Deque[int]{Minsize: 4, Shrink: ShrinkIfEmpty}
+	d.Push(0, 0, 1, 2)
+	d.Shift()
+	d.Shift()
+	d.Push(3) // wraps
+	backing := d.dat
+	d.ReplaceAll([]int{4, 5, 6})
+	if &d.dat[0] != &backing[0] {
+		t.Error("expected backing array to be reused")
+	}
+	es := []int{4, 5, 6, 0}
+	if !reflect.DeepEqual(d.dat, es) {
+		t.Errorf("got %v, expected %v", d.dat, es)
+	}
+	d.ReplaceAll([]int{1, 2, 3, 4, 5})
+	if c := d.Cap(); c != 8 {
+		t.Errorf("capacity %d, expected %d", c, 8)
+	}
+	if d.Minsize != 4 || d.Shrink != ShrinkIfEmpty {
+		t.Errorf("settings %d/%d, expected %d/%d", d.Minsize, d.Shrink, 4, ShrinkIfEmpty)
+	}
+	check(t, d.Shift, []int{1, 2, 3, 4, 5}, true)
+	d.ReplaceAll(nil)
+	check(t, d.Shift, nil, true)
+}
+
+func TestOwnsBackingArray(t *testing.T) {
+	d := Deque[int]{Minsize: 2, Shrink: ShrinkIfEmpty}
+	if d.OwnsBackingArray() {
+		t.Error("got true, expected false")
+	}
+	tmp := make([]int, 4, 8)
+	d.WrapSlice(tmp)
+	if !d.OwnsBackingArray() {
+		t.Error("got false, expected true")
+	}
+	// writes through either side are visible to the other
+	d.Push(5)
+	tmp[0] = 1
+	if v, _ := d.PeekShift(); v != 1 || tmp[:5][4] != 5 {
+		t.Errorf("got %v/%v, expected %v/%v", v, tmp[:5][4], 1, 5)
+	}
+	d.Push(6, 7, 8, 9) // grows
+	if d.OwnsBackingArray() {
+		t.Error("got true, expected false")
+	}
+	tmp[0] = 0
+	check(t, d.Shift, []int{1, 0, 0, 0, 5, 6, 7, 8, 9}, true)
+
+	d.WrapRing(tmp, 2, 3)
+	if !d.OwnsBackingArray() {
+		t.Error("got false, expected true")
+	}
+	d.TakeSlice()
+	if d.OwnsBackingArray() {
+		t.Error("got true, expected false")
+	}
+
+	// results built by package functions own their slices
+	d.Push(1, 2)
+	if Join(&d).OwnsBackingArray() {
+		t.Error("got true, expected false")
+	}
+	if odd, _ := Partition(&d, func(v int) bool { return v%2 == 1 }); odd.OwnsBackingArray() {
+		t.Error("got true, expected false")
+	}
+}
+
+func TestFoldEnds(t *testing.T) {
+	pal := func(acc bool, front, back rune) bool { return acc && front == back }
+	d := Deque[rune]{Minsize: 8}
+	d.Push([]rune("xxxxxrac")...)
+	d.Discard(5)
+	d.Push([]rune("ecar")...) // wraps
+	if !FoldEnds(&d, true, pal) {
+		t.Error("got false, expected true")
+	}
+	d.Pop()
+	if FoldEnds(&d, true, pal) {
+		t.Error("got true, expected false")
+	}
+
+	var pairs [][2]rune
+	FoldEnds(&d, 0, func(acc int, front, back rune) int {
+		pairs = append(pairs, [2]rune{front, back})
+		return acc
+	})
+	es := [][2]rune{{'r', 'a'}, {'a', 'c'}, {'c', 'e'}}
+	if !reflect.DeepEqual(pairs, es) {
+		t.Errorf("got %q, expected %q", pairs, es)
+	}
+	if !FoldEnds(&Deque[rune]{}, true, pal) {
+		t.Error("got false, expected true")
+	}
+}
+
+func TestAppendOnly(t *testing.T) {
+	d := Deque[int]{AppendOnly: true}
+	d.Push(1, 2, 3)
+	check(t, d.Shift, []int{1}, false)
+	for name, op := range map[string]func(){
+		"Unshift":            func() { d.Unshift(0) },
+		"PrependSlice":       func() { d.PrependSlice([]int{0}) },
+		"Pop":                func() { d.Pop() },
+		"DiscardBack":        func() { d.DiscardBack(1) },
+		"TransferTailToHead": func() { d.TransferTailToHead(&Deque[int]{}, 1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", name)
+				}
+			}()
+			op()
+		}()
+	}
+	check(t, d.Shift, []int{2, 3}, true)
+}
+
+func TestCapacityFor(t *testing.T) {
+	for _, tc := range [][3]int{{0, 0, 0}, {0, 1, 32}, {0, 33, 64}, {3, 3, 3}, {3, 7, 12}, {100, 250, 400}} {
+		if c := CapacityFor(tc[0], tc[1]); c != tc[2] {
+			t.Errorf("minsize %d, target %d: got %d, expected %d", tc[0], tc[1], c, tc[2])
+		}
+		d := Deque[int]{Minsize: tc[0]}
+		d.Push(make([]int, tc[1])...)
+		if c := d.Cap(); c != tc[2] {
+			t.Errorf("minsize %d, target %d: capacity %d, expected %d", tc[0], tc[1], c, tc[2])
+		}
+	}
+}
+
+func TestDrainBackward(t *testing.T) {
+	d := Deque[int]{Minsize: 2, Shrink: ShrinkIfEmpty, ZeroSet: true}
+	d.Push(0, 1, 2, 3)
+	d.Shift()
+	d.Push(4, 5) // wraps
+	var got []int
+	for v := range d.DrainBackward() {
+		got = append(got, v)
+		if v == 3 {
+			break
+		}
+	}
+	es := []int{5, 4, 3}
+	if !reflect.DeepEqual(got, es) {
+		t.Errorf("got %v, expected %v", got, es)
+	}
+	if c := d.Cap(); c != 8 {
+		t.Errorf("capacity %d, expected %d", c, 8)
+	}
+	got = nil
+	for v := range d.DrainBackward() {
+		got = append(got, v)
+		if c := d.Cap(); c != 8 {
+			t.Errorf("capacity %d, expected %d", c, 8)
+		}
+	}
+	es = []int{2, 1}
+	if !reflect.DeepEqual(got, es) {
+		t.Errorf("got %v, expected %v", got, es)
+	}
+	if c := d.Cap(); c != 2 {
+		t.Errorf("capacity %d, expected %d", c, 2)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	d := Deque[[]int]{Minsize: 4}
+	d.Push(nil, nil, []int{1, 2}, nil)
+	d.Shift()
+	d.Shift()
+	d.Push([]int{3}, []int{4, 5, 6}) // wraps
+	r := Flatten(&d)
+	if c := r.Cap(); c != 6 {
+		t.Errorf("capacity %d, expected %d", c, 6)
+	}
+	check(t, r.Shift, []int{1, 2, 3, 4, 5, 6}, true)
+	if n := d.Len(); n != 4 {
+		t.Errorf("length %d, expected %d", n, 4)
+	}
+}
+
+func TestCopyConfigFrom(t *testing.T) {
+	src := Deque[int]{Minsize: 4, Shrink: ShrinkAt20Pct, ShrinkFloor: 2, GrowBase: 8, MaxResize: 64}
+	d := Deque[int]{Minsize: 2}
+	d.Push(make([]int, 17)...)
+	d.Discard(16)
+	d.CopyConfigFrom(&src)
+	if d.Minsize != 4 || d.Shrink != ShrinkAt20Pct || d.ShrinkFloor != 2 ||
+		d.GrowBase != 8 || d.MaxResize != 64 || d.Policy != nil {
+		t.Errorf("got %+v, expected settings copied", d)
+	}
+	if c := d.Cap(); c != 4 {
+		t.Errorf("capacity %d, expected %d", c, 4)
+	}
+	d.Push(1, 2, 3, 4)
+	if c := d.Cap(); c != 8 {
+		t.Errorf("capacity %d, expected %d", c, 8)
+	}
+	check(t, d.Shift, []int{0, 1, 2, 3, 4}, true)
+}
+
+func TestRotateAndCompact(t *testing.T) {
+	d := wrappedAt(t, 3, 1, 2, 3, 4, 5, 6, 7)
+	d.RotateAndCompact(-2)
+	if d.head != 0 {
+		t.Errorf("head %d, expected %d", d.head, 0)
+	}
+	backing := d.dat
+	s := d.ToSlice()
+	if &s[0] != &backing[0] {
+		t.Error("expected ToSlice not to copy")
+	}
+	es := []int{6, 7, 1, 2, 3, 4, 5}
+	if !reflect.DeepEqual(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+	d.RotateAndCompact(9)
+	check(t, d.Shift, []int{1, 2, 3, 4, 5, 6, 7}, true)
+	d.RotateAndCompact(1)
+}
+
+func TestShrinkDecider(t *testing.T) {
+	checkcap := func(dd Deque[int], ec int) {
+		if c := dd.Cap(); c != ec {
+			t.Errorf("capacity %d, expected %d", c, ec)
+		}
+	}
+
+	allow := false
+	d := Deque[int]{Minsize: 4, ShrinkDecider: func(len, cap, minsize int) (int, bool) {
+		return len * 2, allow
+	}}
+	d.Push(make([]int, 17)...)
+	checkcap(d, 32)
+	d.Discard(10)
+	checkcap(d, 32)
+	allow = true
+	d.Shift()
+	checkcap(d, 12)
+	d.Discard(5)
+	checkcap(d, 4)
+	check(t, d.Shift, []int{0}, true)
+	checkcap(d, 4)
+
+	// targets no smaller than the capacity, after raising to Minsize,
+	// leave the store alone
+	d = Deque[int]{Minsize: 8, ShrinkDecider: func(len, cap, minsize int) (int, bool) {
+		return len, true
+	}}
+	d.WrapSlice([]int{1, 2, 3, 4})
+	d.Pop()
+	checkcap(d, 4)
+	d.ShrinkDecider = func(len, cap, minsize int) (int, bool) { return cap * 2, true }
+	d.Minsize = 1
+	d.Pop()
+	checkcap(d, 4)
+}
+
+func TestLastInto(t *testing.T) {
+	d := wrapped(t)
+	d.Pop()
+
+	dst := make([]int, 4)
+	if n := d.LastInto(2, dst); n != 2 {
+		t.Errorf("copied %d, expected %d", n, 2)
+	}
+	es := []int{4, 5, 0, 0}
+	if !reflect.DeepEqual(dst, es) {
+		t.Errorf("got %v, expected %v", dst, es)
+	}
+	if n := d.LastInto(5, dst); n != 3 {
+		t.Errorf("copied %d, expected %d", n, 3)
+	}
+	es = []int{3, 4, 5, 0}
+	if !reflect.DeepEqual(dst, es) {
+		t.Errorf("got %v, expected %v", dst, es)
+	}
+	if n := d.LastInto(3, dst[:1]); n != 1 || dst[0] != 5 {
+		t.Errorf("copied %d/%d, expected %d/%d", n, dst[0], 1, 5)
+	}
+	if n := d.LastInto(-1, dst); n != 0 {
+		t.Errorf("copied %d, expected %d", n, 0)
+	}
+}
+
+func BenchmarkLastInto(b *testing.B) {
+	d := Deque[int]{Minsize: 1024}
+	d.Push(make([]int, 1024)...)
+	d.Discard(512)
+	d.Push(make([]int, 256)...) // wraps
+	dst := make([]int, 512)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d.LastInto(len(dst), dst)
+	}
+}
+
+func TestDebugDump(t *testing.T) {
+	d := Deque[int]{Minsize: 5}
+	if s := d.DebugDump(); s != "[]" {
+		t.Errorf("got %q, expected %q", s, "[]")
+	}
+	d.Push(0, 0, 0, 1)
+	if s, es := d.DebugDump(), "[H:0, 0, 0, T:1, _]"; s != es {
+		t.Errorf("got %q, expected %q", s, es)
+	}
+	d.Discard(3)
+	if s, es := d.DebugDump(), "[_, _, _, HT:1, _]"; s != es {
+		t.Errorf("got %q, expected %q", s, es)
+	}
+	d.Push(2, 3) // wraps
+	if s, es := d.DebugDump(), "[T:3, _, _, H:1, 2]"; s != es {
+		t.Errorf("got %q, expected %q", s, es)
+	}
+	d.Discard(3)
+	if s, es := d.DebugDump(), "[T:_, H:_, _, _, _]"; s != es {
+		t.Errorf("got %q, expected %q", s, es)
+	}
+}
+
+func TestApplyTo(t *testing.T) {
+	ops := Deque[Op[rune]]{Minsize: 4}
+	ops.Push(Op[rune]{}, Op[rune]{}, Op[rune]{OpInsert, 0, 'c'}, Op[rune]{OpDelete, 2, 0})
+	ops.Discard(2)
+	ops.Push(Op[rune]{OpReplace, 1, 'u'}, Op[rune]{OpInsert, 3, 's'}) // wraps
+	base := []rune("cat")
+	s := ApplyTo(&ops, base)
+	if string(s) != "cuts" {
+		t.Errorf("got %q, expected %q", string(s), "cuts")
+	}
+	if string(base) != "cat" {
+		t.Errorf("got %q, expected base unchanged", string(base))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	ops.Push(Op[rune]{OpDelete, 9, 0})
+	ApplyTo(&ops, base)
+}
+
+func TestReservedCap(t *testing.T) {
+	checkcap := func(dd Deque[int], ec int) {
+		if c := dd.Cap(); c != ec {
+			t.Errorf("capacity %d, expected %d", c, ec)
+		}
+	}
+
+	d := Deque[int]{Minsize: 2, Shrink: ShrinkIfEmpty, ReservedCap: 8}
+	d.Push(make([]int, 9)...)
+	checkcap(d, 16)
+	d.Discard(9)
+	checkcap(d, 8)
+	d.Push(1)
+	d.Shift()
+	checkcap(d, 8)
+
+	d = Deque[int]{Minsize: 3, Policy: stepPolicy{}, ReservedCap: 6}
+	d.Push(make([]int, 9)...)
+	d.Discard(9)
+	checkcap(d, 6)
+}
+
+func TestShuffleRand(t *testing.T) {
+	shuffled := func(seed uint64) []int {
+		d := wrappedAt(t, 3, 1, 2, 3, 4, 5, 6, 7, 8)
+		d.ShuffleRand(rand.New(rand.NewPCG(seed, 0)))
+		if c := d.Cap(); c != 8 {
+			t.Errorf("capacity %d, expected %d", c, 8)
+		}
+		return d.ToSlice()
+	}
+
+	a, b := shuffled(1), shuffled(1)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("got %v and %v, expected the same order for the same seed", a, b)
+	}
+	s := slices.Clone(a)
+	slices.Sort(s)
+	es := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	if !reflect.DeepEqual(s, es) {
+		t.Errorf("got %v, expected a permutation of %v", a, es)
+	}
+	if reflect.DeepEqual(a, es) && reflect.DeepEqual(shuffled(2), es) {
+		t.Error("expected a shuffled order")
+	}
+}
+
+func TestCoalesceAdjacent(t *testing.T) {
+	type run struct{ key, count int }
+	sum := func(a, b run) (run, bool) {
+		if a.key != b.key {
+			return a, false
+		}
+		return run{a.key, a.count + b.count}, true
+	}
+	d := Deque[run]{Minsize: 8}
+	d.Push(run{}, run{}, run{}, run{}, run{1, 1}, run{1, 2}, run{2, 1}, run{2, 1})
+	d.Discard(4)
+	d.Push(run{2, 5}, run{1, 1}, run{3, 1}) // wraps
+	if n := d.CoalesceAdjacent(sum); n != 3 {
+		t.Errorf("merged %d, expected %d", n, 3)
+	}
+	es := []run{{1, 3}, {2, 7}, {1, 1}, {3, 1}}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	checkCleared(t, &d)
+	if n := d.CoalesceAdjacent(sum); n != 0 {
+		t.Errorf("merged %d, expected %d", n, 0)
+	}
+}
+
+func TestInsertAtFast(t *testing.T) {
+	d := wrappedAt(t, 5, 1, 2, 3, 5, 6)
+	d.InsertAtFast(1, 10) // head side
+	d.InsertAtFast(5, 40) // tail side
+	d.InsertAtFast(0, 0)
+	d.InsertAtFast(d.Len(), 7)
+	es := []int{0, 1, 10, 2, 3, 5, 40, 6, 7}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+
+	// values keep their sequence numbers as they shift
+	d = Deque[int]{Minsize: 8}
+	d.TrackSeq()
+	d.Push(1, 2, 3, 4, 5, 6, 7, 8)
+	d.Discard(3)
+	d.InsertAtFast(1, 99) // head side
+	d.InsertAtFast(5, 98) // tail side
+	var s []uint64
+	for i := 0; i < d.Len(); i++ {
+		n, _ := d.SeqAt(i)
+		s = append(s, n)
+	}
+	if es := []uint64{3, 8, 4, 5, 6, 9, 7}; !slices.Equal(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+
+	d.AppendOnly = true
+	d.InsertAtFast(d.Len(), 9)
+	for _, i := range []int{d.Len() + 1, 0} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for InsertAtFast(%d, 0)", i)
+				}
+			}()
+			d.InsertAtFast(i, 0)
+		}()
+	}
+}
+
+// Insert by always shifting the values after i toward the tail.
+func insertTailward(d *Deque[int], i, v int) {
+	d.Push(v)
+	for k := d.len - 1; k > i; k-- {
+		a, b := d.index(k), d.index(k-1)
+		d.dat[a], d.dat[b] = d.dat[b], d.dat[a]
+	}
+}
+
+func benchmarkInsert(b *testing.B, insert func(d *Deque[int], i, v int)) {
+	for n := 0; n < b.N; n++ {
+		var d Deque[int]
+		for i := 0; i < 1000; i++ {
+			insert(&d, d.Len()/4, i)
+		}
+	}
+}
+
+func BenchmarkInsertAtFast(b *testing.B) {
+	benchmarkInsert(b, func(d *Deque[int], i, v int) { d.InsertAtFast(i, v) })
+}
+
+func BenchmarkInsertTailward(b *testing.B) {
+	benchmarkInsert(b, insertTailward)
+}
+
+func TestScan(t *testing.T) {
+	d := wrapped(t)
+	for e := range d.Scan() {
+		if e.Value()%2 == 0 {
+			e.Remove()
+		}
+	}
+	es := []int{3, 5}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	checkCleared(t, &d)
+	d.Push(7, 8)
+	for e := range d.Scan() {
+		e.Remove()
+		if e.Value() == 5 {
+			break
+		}
+	}
+	es = []int{7, 8}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+}
+
+func TestPushSoft(t *testing.T) {
+	d := Deque[int]{SoftCap: 3}
+	if d.PushSoft(1, 2, 3) {
+		t.Errorf("exceeded at length %d, soft cap %d", d.Len(), d.SoftCap)
+	}
+	if !d.PushSoft(4) {
+		t.Errorf("not exceeded at length %d, soft cap %d", d.Len(), d.SoftCap)
+	}
+	es := []int{1, 2, 3, 4}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	d.SoftCap = 0
+	if d.PushSoft(5) {
+		t.Errorf("exceeded with no soft cap")
+	}
+}
+
+func TestReverseRange(t *testing.T) {
+	d := wrappedAt(t, 5, 1, 2, 3, 4, 5, 6, 7)
+	c := cap(d.dat)
+	d.ReverseRange(1, 5)
+	es := []int{1, 5, 4, 3, 2, 6, 7}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	d.ReverseRange(3, 3)
+	d.ReverseRange(5, 8)
+	d.ReverseRange(-1, 2)
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	d.ReverseRange(0, d.Len())
+	slices.Reverse(es)
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	if cap(d.dat) != c {
+		t.Errorf("capacity %d, expected %d", cap(d.dat), c)
+	}
+}
+
+func TestCloneInto(t *testing.T) {
+	d := wrapped(t)
+	dst := Deque[int]{Minsize: 8, Shrink: 2}
+	dst.Push(9, 9, 9, 9, 9, 9)
+	dst.CloneInto(&dst)
+	store := &dst.dat[0]
+	d.CloneInto(&dst)
+	es := []int{3, 4, 5, 6}
+	if !EqualSlice(&dst, es) {
+		t.Errorf("got %v, expected %v", dst.ToSlice(), es)
+	}
+	if &dst.dat[0] != store {
+		t.Errorf("backing store replaced, expected it reused")
+	}
+	if dst.Minsize != 8 || dst.Shrink != 2 {
+		t.Errorf("settings %d, %d, expected %d, %d", dst.Minsize, dst.Shrink, 8, 2)
+	}
+	checkCleared(t, &dst)
+	d.Update(func(_ int, v int) int { return -v })
+	if !EqualSlice(&dst, es) {
+		t.Errorf("got %v, expected %v", dst.ToSlice(), es)
+	}
+	d.Push(7, 8, 9, 10, 11)
+	d.CloneInto(&dst)
+	es = []int{-3, -4, -5, -6, 7, 8, 9, 10, 11}
+	if !EqualSlice(&dst, es) {
+		t.Errorf("got %v, expected %v", dst.ToSlice(), es)
+	}
+}
+
+func TestUpdateFirst(t *testing.T) {
+	d := wrapped(t)
+	even := func(v int) bool { return v%2 == 0 }
+	double := func(v int) int { return v * 2 }
+	if !d.UpdateFirst(even, double) {
+		t.Errorf("no match, expected one")
+	}
+	es := []int{3, 8, 5, 6}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	if d.UpdateFirst(func(v int) bool { return v > 20 }, double) {
+		t.Errorf("match, expected none")
+	}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+}
+
+func TestSharesBacking(t *testing.T) {
+	s := make([]int, 8)
+	var a, b, c Deque[int]
+	if SharesBacking(&a, &b) {
+		t.Errorf("empty deques share backing")
+	}
+	a.WrapSlice(s)
+	b.WrapSlice(s[2:6])
+	c.Push(1, 2, 3)
+	if !SharesBacking(&a, &b) {
+		t.Errorf("wrapped deques do not share backing")
+	}
+	if SharesBacking(&a, &c) {
+		t.Errorf("separate deques share backing")
+	}
+	a.CloneInto(&c)
+	if SharesBacking(&a, &c) {
+		t.Errorf("clone shares backing")
+	}
+}
+
+func TestStride(t *testing.T) {
+	d := wrapped(t)
+	collect := func(start, step int) (is, vs []int) {
+		for i, v := range d.Stride(start, step) {
+			is = append(is, i)
+			vs = append(vs, v)
+		}
+		return
+	}
+	for _, c := range []struct {
+		start, step int
+		is, vs      []int
+	}{
+		{0, 1, []int{0, 1, 2, 3}, []int{3, 4, 5, 6}},
+		{1, 2, []int{1, 3}, []int{4, 6}},
+		{3, -2, []int{3, 1}, []int{6, 4}},
+		{2, 0, nil, nil},
+		{4, -1, nil, nil},
+	} {
+		is, vs := collect(c.start, c.step)
+		if !slices.Equal(is, c.is) || !slices.Equal(vs, c.vs) {
+			t.Errorf("Stride(%d, %d) got %v %v, expected %v %v",
+				c.start, c.step, is, vs, c.is, c.vs)
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	d := wrappedAt(t, 5, 1, 2, 1, 3, 2, 4)
+	if n := Dedup(&d); n != 2 {
+		t.Errorf("removed %d, expected %d", n, 2)
+	}
+	es := []int{1, 2, 3, 4}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	checkCleared(t, &d)
+	if n := DedupFunc(&d, func(v int) int { return v % 2 }); n != 2 {
+		t.Errorf("removed %d, expected %d", n, 2)
+	}
+	es = []int{1, 2}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	var highs []int
+	d := Deque[int]{Minsize: 4, HighWatermark: 5}
+	d.OnHighWatermark = func(n int) { highs = append(highs, n) }
+	d.Push(1)
+	b := d.BulkLoad(9)
+	c := cap(d.dat)
+	if c < 10 {
+		t.Errorf("capacity %d, expected at least %d", c, 10)
+	}
+	b.Add([]int{2, 3, 4})
+	b.Add(nil)
+	b.Add([]int{5, 6, 7, 8, 9, 10})
+	if cap(d.dat) != c {
+		t.Errorf("capacity %d, expected %d", cap(d.dat), c)
+	}
+	if len(highs) != 0 {
+		t.Errorf("watermark fired before Done")
+	}
+	b.Done()
+	b.Done()
+	if !slices.Equal(highs, []int{10}) {
+		t.Errorf("got %v, expected %v", highs, []int{10})
+	}
+	d.Push(11)
+	es := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic for Add after Done")
+		}
+	}()
+	b.Add([]int{12})
+}
+
+func TestShiftToChannelBatched(t *testing.T) {
+	d := wrapped(t)
+	ch := make(chan []int, 2)
+	d.ShiftToChannelBatched(ch, 3)
+	close(ch)
+	var got [][]int
+	for c := range ch {
+		got = append(got, c)
+	}
+	es := [][]int{{3, 4, 5}, {6}}
+	if !reflect.DeepEqual(got, es) {
+		t.Errorf("got %v, expected %v", got, es)
+	}
+	if d.Len() != 0 {
+		t.Errorf("length %d, expected %d", d.Len(), 0)
+	}
+	checkCleared(t, &d)
+}
+
+func TestQuantizeCap(t *testing.T) {
+	pow2 := func(n int) int {
+		c := 1
+		for c < n {
+			c *= 2
+		}
+		return c
+	}
+	d := Deque[int]{Minsize: 48, Shrink: ShrinkAt20Pct, QuantizeCap: pow2}
+	checkcap := func(c int) {
+		t.Helper()
+		if d.Cap() != c {
+			t.Errorf("capacity %d, expected %d", d.Cap(), c)
+		}
+	}
+	d.Push(1)
+	checkcap(64)
+	d.Push(make([]int, 100)...)
+	checkcap(128)
+	d.Discard(90)
+	checkcap(64)
+	d.QuantizeCap = func(int) int { return 0 }
+	d.Push(make([]int, 60)...)
+	checkcap(128)
+	var e Deque[int]
+	e.CopyConfigFrom(&d)
+	if e.QuantizeCap == nil {
+		t.Errorf("QuantizeCap not copied")
+	}
+}
+
+func TestPeekRangeSegments(t *testing.T) {
+	d := wrapped(t)
+	for _, c := range []struct {
+		i, j int
+		a, b []int
+	}{
+		{0, 2, []int{3, 4}, nil},
+		{-1, 9, []int{3, 4}, []int{5, 6}},
+		{1, 3, []int{4}, []int{5}},
+		{2, 4, []int{5, 6}, nil},
+		{3, 1, nil, nil},
+	} {
+		a, b := d.PeekRangeSegments(c.i, c.j)
+		if !slices.Equal(a, c.a) || !slices.Equal(b, c.b) {
+			t.Errorf("PeekRangeSegments(%d, %d) got %v %v, expected %v %v",
+				c.i, c.j, a, b, c.a, c.b)
+		}
+	}
+	a, _ := d.PeekRangeSegments(0, 1)
+	a[0] = 7
+	check(t, d.Shift, []int{7, 4, 5, 6}, true)
+}
+
+func TestReduceRight(t *testing.T) {
+	d := Deque[string]{Minsize: 4}
+	d.Push("a", "b", "c", "d")
+	d.Shift()
+	d.Shift()
+	d.Push("e", "f") // wraps
+	s := ReduceRight(&d, "<", func(acc string, v string) string { return acc + v })
+	if s != "<fedc" {
+		t.Errorf("got %q, expected %q", s, "<fedc")
+	}
+	n := ReduceRight(&Deque[string]{}, 7, func(acc int, _ string) int { return acc + 1 })
+	if n != 7 {
+		t.Errorf("got %d, expected %d", n, 7)
+	}
+}
+
+func TestGrowStep(t *testing.T) {
+	d := Deque[int]{Minsize: 4, GrowStep: 3, GrowBase: 16}
+	checkcap := func(c int) {
+		t.Helper()
+		if d.Cap() != c {
+			t.Errorf("capacity %d, expected %d", d.Cap(), c)
+		}
+	}
+	d.Push(1)
+	checkcap(4)
+	d.Push(2, 3, 4, 5)
+	checkcap(7)
+	d.Push(make([]int, 7)...)
+	checkcap(13)
+	d.MaxResize = 2
+	d.Push(make([]int, 2)...)
+	checkcap(15)
+	var e Deque[int]
+	e.CopyConfigFrom(&d)
+	if e.GrowStep != 3 {
+		t.Errorf("GrowStep %d, expected %d", e.GrowStep, 3)
+	}
+}
+
+func TestIndexOfMinMax(t *testing.T) {
+	less := func(a, b int) bool { return a < b }
+	var d Deque[int]
+	if i, j := d.IndexOfMin(less), d.IndexOfMax(less); i != -1 || j != -1 {
+		t.Errorf("got %d, %d, expected %d, %d", i, j, -1, -1)
+	}
+	d = wrappedAt(t, 5, 3, 9, 1, 9, 1, 5)
+	if i, j := d.IndexOfMin(less), d.IndexOfMax(less); i != 2 || j != 1 {
+		t.Errorf("got %d, %d, expected %d, %d", i, j, 2, 1)
+	}
+}
+
+func TestRotateToMin(t *testing.T) {
+	less := func(a, b int) bool { return a < b }
+	var d Deque[int]
+	if d.RotateToMin(less) {
+		t.Errorf("rotated empty deque")
+	}
+	d.Minsize = 4
+	d.Push(0, 0, 5, 6)
+	d.Discard(2)
+	d.Push(1, 7) // wraps
+	c := cap(d.dat)
+	if !d.RotateToMin(less) {
+		t.Errorf("not rotated, expected rotation")
+	}
+	es := []int{1, 7, 5, 6}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	d.Pop()
+	d.RotateToMin(less)
+	es = []int{1, 7, 5}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	d.Push(0)
+	d.RotateToMin(less)
+	es = []int{0, 1, 7, 5}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	if cap(d.dat) != c {
+		t.Errorf("capacity %d, expected %d", cap(d.dat), c)
+	}
+}
+
+func TestRemoveIndices(t *testing.T) {
+	d := wrappedAt(t, 5, 1, 2, 3, 4, 5, 6, 7)
+	if n := d.RemoveIndices([]int{-1, 1, 2, 2, 4, 3, 9}); n != 3 {
+		t.Errorf("removed %d, expected %d", n, 3)
+	}
+	es := []int{1, 4, 6, 7}
+	if !EqualSlice(&d, es) {
+		t.Errorf("got %v, expected %v", d.ToSlice(), es)
+	}
+	checkCleared(t, &d)
+	if n := d.RemoveIndices(nil); n != 0 {
+		t.Errorf("removed %d, expected %d", n, 0)
+	}
+}
+
+func TestMakeContiguous(t *testing.T) {
+	d := wrappedAt(t, 5, 1, 2, 3, 4, 5)
+	backing := d.Backing()
+	d.MakeContiguous()
+	if d.Head() != 0 {
+		t.Errorf("head %d, expected %d", d.Head(), 0)
+	}
+	if &d.Backing()[0] != &backing[0] || d.Cap() != 8 {
+		t.Errorf("backing store replaced, expected it reused")
+	}
+	s := d.Backing()[:d.Len()]
+	es := []int{1, 2, 3, 4, 5}
+	if !slices.Equal(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+	d.Push(6)
+	check(t, d.Shift, []int{1, 2, 3, 4, 5, 6}, true)
+}
+
+func TestPopReleases(t *testing.T) {
+	// values under 16 bytes without pointers may share a block, so
+	// use a [2]int to have the finalizer run alone
+	freed := make(chan int, 3)
+	d := Deque[*[2]int]{Minsize: 4}
+	for i := 0; i < 3; i++ {
+		p := &[2]int{i}
+		runtime.SetFinalizer(p, func(p *[2]int) { freed <- p[0] })
+		d.Push(p)
+	}
+	collected := func(op string, e int) {
+		t.Helper()
+		runtime.GC()
+		select {
+		case i := <-freed:
+			if i != e {
+				t.Fatalf("%s: collected %d, expected %d", op, i, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: %d not collected after removal", op, e)
+		}
+	}
+	d.Pop()
+	collected("Pop", 2)
+	d.Shift()
+	collected("Shift", 0)
+	runtime.KeepAlive(&d)
+}
+
+func TestAt(t *testing.T) {
+	d := wrapped(t)
+	for i, e := range []int{3, 4, 5, 6} {
+		if v, ok := d.At(i); !ok || v != e {
+			t.Errorf("got %v/%v, expected %v/true", v, ok, e)
+		}
+	}
+	for _, i := range []int{-1, 4} {
+		if v, ok := d.At(i); ok || v != 0 {
+			t.Errorf("got %v/%v, expected 0/false", v, ok)
+		}
+	}
+	if d.Len() != 4 || d.Cap() != 4 {
+		t.Errorf("length/capacity %d/%d, expected %d/%d", d.Len(), d.Cap(), 4, 4)
+	}
+}
+
+func TestSet(t *testing.T) {
+	d := wrapped(t)
+	head, tail := d.Head(), d.Tail()
+	if !d.Set(1, 40) || !d.Set(3, 60) {
+		t.Errorf("set failed, expected success")
+	}
+	if d.Set(-1, 0) || d.Set(4, 0) {
+		t.Errorf("set succeeded out of range")
+	}
+	if v, ok := d.At(3); !ok || v != 60 {
+		t.Errorf("got %v/%v, expected %v/true", v, ok, 60)
+	}
+	if h, tl := d.Head(), d.Tail(); h != head || tl != tail {
+		t.Errorf("head/tail %d/%d, expected %d/%d", h, tl, head, tail)
+	}
+	es := []int{3, 40, 5, 60}
+	if s := d.ToSlice(); !slices.Equal(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+}
+
+func TestAll(t *testing.T) {
+	d := wrapped(t)
+	var s []int
+	for v := range d.All() {
+		s = append(s, v)
+	}
+	es := []int{3, 4, 5, 6}
+	if !slices.Equal(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+	s = s[:0]
+	for v := range d.All() {
+		s = append(s, v)
+		if v == 4 {
+			break
+		}
+	}
+	es = []int{3, 4}
+	if !slices.Equal(s, es) {
+		t.Errorf("got %v, expected %v", s, es)
+	}
+	if d.Len() != 4 {
+		t.Errorf("length %d, expected %d", d.Len(), 4)
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/dan4thewin/go-deque/deque
 
-go 1.20
+go 1.23
@@ -0,0 +1,74 @@
+// Copyright 2023 Dan Good. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package deque
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Return the values along with Minsize, Shrink, and the capacity, in
+// a compact little-endian encoding.  T must be a fixed-size type, as
+// defined by encoding/binary; int and uint are not.
+func (d *Deque[T]) MarshalState() ([]byte, error) {
+	var zero T
+	size := binary.Size(zero)
+	if size < 0 {
+		return nil, errors.New("deque: MarshalState element type is not fixed-size")
+	}
+	var buf bytes.Buffer
+	buf.Grow(32 + d.len*size)
+	hdr := []int64{int64(d.Minsize), int64(d.Shrink), int64(cap(d.dat)), int64(d.len)}
+	if err := binary.Write(&buf, binary.LittleEndian, hdr); err != nil {
+		return nil, err
+	}
+	a, b := d.segments(0, d.len)
+	if err := binary.Write(&buf, binary.LittleEndian, a); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, b); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore the values, Minsize, Shrink, and capacity from the output
+// of MarshalState, with the head at index 0.  Other settings are
+// unchanged.  The capacity is allocated as given, so use only with
+// trusted input.
+func (d *Deque[T]) UnmarshalState(data []byte) error {
+	var zero T
+	elem := binary.Size(zero)
+	if elem < 0 {
+		return errors.New("deque: UnmarshalState element type is not fixed-size")
+	}
+	r := bytes.NewReader(data)
+	hdr := make([]int64, 4)
+	if err := binary.Read(r, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+	size, length := hdr[2], hdr[3]
+	if length < 0 || length > size || size > int64(math.MaxInt/max(elem, 1)) {
+		return errors.New("deque: UnmarshalState invalid length or capacity")
+	}
+	dat := make([]T, size)
+	if err := binary.Read(r, binary.LittleEndian, dat[:length]); err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return errors.New("deque: UnmarshalState trailing data")
+	}
+	d.Minsize, d.Shrink = int(hdr[0]), int(hdr[1])
+	d.release()
+	d.dat, d.adopted = dat, false
+	d.head = 0
+	d.len = int(length)
+	d.settail()
+	d.retag()
+	d.watermark()
+	return nil
+}
@@ -0,0 +1,51 @@
+package deque
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalState(t *testing.T) {
+	d := Deque[int32]{Minsize: 4, Shrink: ShrinkAt20Pct}
+	d.Push(1, 2, 3, 4)
+	d.Shift()
+	d.Shift()
+	d.Push(5, 6) // wraps
+	d.Push(7)
+	data, err := d.MarshalState()
+	if err != nil {
+		t.Fatalf("got %v, expected nil", err)
+	}
+
+	var r Deque[int32]
+	if err := r.UnmarshalState(data); err != nil {
+		t.Fatalf("got %v, expected nil", err)
+	}
+	if r.Minsize != 4 || r.Shrink != ShrinkAt20Pct || r.Cap() != 8 {
+		t.Errorf("settings %d/%d/%d, expected %d/%d/%d",
+			r.Minsize, r.Shrink, r.Cap(), 4, ShrinkAt20Pct, 8)
+	}
+	if !EqualSlice(&r, []int32{3, 4, 5, 6, 7}) {
+		t.Errorf("got %v, expected %v", r.ToSlice(), []int32{3, 4, 5, 6, 7})
+	}
+
+	if err := r.UnmarshalState(data[:len(data)-1]); err == nil {
+		t.Error("got nil, expected error for truncated data")
+	}
+	if err := r.UnmarshalState(append(data, 0)); err == nil {
+		t.Error("got nil, expected error for trailing data")
+	}
+
+	// the replaced store is cleared when ZeroOnGrow is set
+	r.ZeroOnGrow = true
+	old := r.dat
+	if err := r.UnmarshalState(data); err != nil {
+		t.Fatalf("got %v, expected nil", err)
+	}
+	if es := make([]int32, 8); !reflect.DeepEqual(old, es) {
+		t.Errorf("got %v, expected %v", old, es)
+	}
+	if _, err := (&Deque[int]{}).MarshalState(); err == nil {
+		t.Error("got nil, expected error for int elements")
+	}
+}